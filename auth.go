@@ -0,0 +1,207 @@
+package httpwatch
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// sessionCookie holds the session created by signing in with a login token.
+const sessionCookie = "http_watch_session"
+
+type AuthConfig struct {
+	// Users maps user names to password hashes for basic auth, in a format
+	// supported by ReadHtpasswd.
+	Users map[string]string
+	// LoginToken, when set, signs in the first request carrying it as login
+	// query parameter or bearer token, which receives a session cookie.
+	LoginToken string
+}
+
+// Auth requires requests to authenticate with basic auth, a session cookie
+// or the one-time login token.
+type Auth struct {
+	cfg AuthConfig
+
+	mu         sync.Mutex
+	tokenUsed  bool
+	sessionIDs map[string]bool
+}
+
+func NewAuth(cfg AuthConfig) *Auth {
+	return &Auth{cfg: cfg, sessionIDs: map[string]bool{}}
+}
+
+// NewLoginToken returns a random token for AuthConfig.LoginToken.
+func NewLoginToken() string {
+	return randomHex(16)
+}
+
+// ReadHtpasswd reads user:hash lines from an htpasswd file. Supported hashes
+// are {SHA} and SHA-crypt ($5$ and $6$).
+func ReadHtpasswd(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("%s:%d: expected user:hash", path, n)
+		}
+		if !strings.HasPrefix(hash, "{SHA}") && !strings.HasPrefix(hash, "$5$") && !strings.HasPrefix(hash, "$6$") {
+			return nil, fmt.Errorf("%s:%d: unsupported hash for %q, use {SHA}, $5$ or $6$", path, n, user)
+		}
+		users[user] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return users, nil
+}
+
+// checkPassword reports if password matches the htpasswd hash.
+func checkPassword(password, hash string) bool {
+	var expected string
+	if digest, ok := strings.CutPrefix(hash, "{SHA}"); ok {
+		sum := sha1.Sum([]byte(password))
+		expected = "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		hash = "{SHA}" + digest
+	} else {
+		var err error
+		if expected, err = shaCrypt(password, hash); err != nil {
+			return false
+		}
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(hash)) == 1
+}
+
+func (a *Auth) checkBasicAuth(r *http.Request) bool {
+	user, password, sent := r.BasicAuth()
+	if !sent {
+		return false
+	}
+	hash, found := a.cfg.Users[user]
+	if !found {
+		// compare anyway to not reveal which users exist
+		hash = "$5$nouser$"
+	}
+	ok := checkPassword(password, hash) && found
+	if !ok {
+		slog.WarnContext(r.Context(), "failed login", "user", user, "remote_addr", r.RemoteAddr)
+	}
+	return ok
+}
+
+func (a *Auth) checkSession(r *http.Request) bool {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.sessionIDs[cookie.Value]
+}
+
+// useToken reports if r carries the login token, which only succeeds once.
+func (a *Auth) useToken(r *http.Request) bool {
+	token := r.URL.Query().Get("login")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		token = bearer
+	}
+	if token == "" {
+		return false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.tokenUsed || subtle.ConstantTimeCompare([]byte(token), []byte(a.cfg.LoginToken)) != 1 {
+		slog.WarnContext(r.Context(), "invalid or used login token", "remote_addr", r.RemoteAddr)
+		return false
+	}
+	a.tokenUsed = true
+	return true
+}
+
+// startSession sets the cookie of a new session on w.
+func (a *Auth) startSession(w http.ResponseWriter, r *http.Request) {
+	id := randomHex(32)
+	a.mu.Lock()
+	a.sessionIDs[id] = true
+	a.mu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	slog.InfoContext(r.Context(), "signed in with login token", "remote_addr", r.RemoteAddr)
+}
+
+// Middleware rejects unauthenticated requests with 401. CORS preflight
+// requests pass, browsers send them without credentials and they are
+// answered by the CORS middleware without reaching a handler.
+func (a *Auth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isPreflight(r) || a.checkSession(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(a.cfg.Users) > 0 {
+			if a.checkBasicAuth(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		if a.cfg.LoginToken != "" && a.useToken(r) {
+			a.startSession(w, r)
+			// drop the token from the address bar
+			if r.Method == http.MethodGet && r.URL.Query().Has("login") {
+				u := *r.URL
+				q := u.Query()
+				q.Del("login")
+				u.RawQuery = q.Encode()
+				http.Redirect(w, r, u.RequestURI(), http.StatusSeeOther)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(a.cfg.Users) > 0 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="http-watch", charset="UTF-8"`)
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic("rand.Read: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
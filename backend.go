@@ -0,0 +1,88 @@
+package httpwatch
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watcher backends selectable via WatcherConfig.Backend.
+const (
+	// BackendAuto uses fsnotify, falling back to polling when it fails.
+	BackendAuto     = "auto"
+	BackendFsnotify = "fsnotify"
+	BackendPoll     = "poll"
+)
+
+const defaultPollInterval = 500 * time.Millisecond
+
+// Backend reports changes to the entries of watched directories.
+type Backend interface {
+	Add(path string) error
+	Remove(path string) error
+	Events() <-chan fsnotify.Event
+	Errors() <-chan error
+	Close() error
+}
+
+type fsnotifyWatcher struct {
+	*fsnotify.Watcher
+}
+
+func (w fsnotifyWatcher) Events() <-chan fsnotify.Event {
+	return w.Watcher.Events
+}
+
+func (w fsnotifyWatcher) Errors() <-chan error {
+	return w.Watcher.Errors
+}
+
+func newFsnotifyWatcher() (Backend, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return fsnotifyWatcher{w}, nil
+}
+
+func newPollWatcherFromConfig(cfg WatcherConfig) Backend {
+	interval := cfg.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	return NewPollWatcher(interval, cfg.PollHash)
+}
+
+// newBackend creates the watcher selected by cfg.Backend.
+func newBackend(cfg WatcherConfig) (Backend, error) {
+	switch cfg.Backend {
+	case BackendPoll:
+		return newPollWatcherFromConfig(cfg), nil
+	case BackendFsnotify:
+		return newFsnotifyWatcher()
+	case "", BackendAuto:
+		w, err := newFsnotifyWatcher()
+		if err != nil {
+			slog.Warn("fsnotify unavailable, falling back to polling", "error", err)
+			return newPollWatcherFromConfig(cfg), nil
+		}
+		return w, nil
+	}
+	return nil, fmt.Errorf("unknown watcher backend: %q", cfg.Backend)
+}
+
+// shouldFallBack reports if an auto backend should switch to polling after
+// adding a watch failed by running out of inotify watches or file descriptors.
+func shouldFallBack(cfg WatcherConfig, w Backend, err error) bool {
+	if cfg.Backend != "" && cfg.Backend != BackendAuto {
+		return false
+	}
+	if _, ok := w.(*PollWatcher); ok {
+		return false
+	}
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EMFILE)
+}
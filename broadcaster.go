@@ -1,47 +1,141 @@
 package httpwatch
 
-import "sync"
+import (
+	"sync"
+	"time"
+)
+
+// Message is an event sent to websocket clients.
+type Message struct {
+	// ID increases with every broadcast message, relayed sync messages have no ID.
+	ID   uint64 `json:"id,omitempty"`
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+const (
+	// leaderTimeout is how long a sync leader keeps control after its last event.
+	leaderTimeout = time.Second
+	// historySize is how many broadcast messages are kept for replay.
+	historySize = 128
+)
 
 // Broadcaster struct manages subscribers and broadcasting events to them.
 type Broadcaster struct {
-	subscribers map[chan string]bool
-	mu          sync.Mutex
+	subscribers map[chan Message]bool
+	// syncing are the subscribers taking part in sync, see JoinSync
+	syncing map[Subscriber]bool
+	mu      sync.Mutex
+
+	lastID  uint64
+	history []Message
+
+	leader     Subscriber
+	leaderSeen time.Time
 }
 
 func NewBroadcaster() *Broadcaster {
 	return &Broadcaster{
-		subscribers: make(map[chan string]bool),
+		subscribers: make(map[chan Message]bool),
+		syncing:     make(map[Subscriber]bool),
 	}
 }
 
-type Subscriber chan string
+type Subscriber chan Message
 
 // AddSubscriber adds a new subscriber channel to the broadcaster.
 func (b *Broadcaster) AddSubscriber() Subscriber {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	ch := make(chan string, 1) // Buffered channel for non-blocking
+	ch := make(chan Message, 8) // Buffered channel for non-blocking
 	b.subscribers[ch] = true
 	return ch
 }
 
+// AddSubscriberSince adds a new subscriber channel and returns the kept
+// messages broadcast after the given ID, so a reconnecting client misses
+// nothing in between.
+func (b *Broadcaster) AddSubscriberSince(id uint64) (Subscriber, []Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch := make(chan Message, 8) // Buffered channel for non-blocking
+	b.subscribers[ch] = true
+
+	var missed []Message
+	for _, msg := range b.history {
+		if msg.ID > id {
+			missed = append(missed, msg)
+		}
+	}
+	return ch, missed
+}
+
 // Remove removes a subscriber channel from the broadcaster.
 func (b *Broadcaster) Remove(ch Subscriber) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
+	if b.leader == ch {
+		b.leader = nil
+	}
 	delete(b.subscribers, ch)
+	delete(b.syncing, ch)
 	close(ch)
 }
 
 // Broadcast sends the message to all active subscribers.
-func (b *Broadcaster) Broadcast(message string) {
+func (b *Broadcaster) Broadcast(msg Message) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
+
+	b.lastID++
+	msg.ID = b.lastID
+	if len(b.history) == historySize {
+		b.history = b.history[1:]
+	}
+	b.history = append(b.history, msg)
+
 	for ch := range b.subscribers {
-		select {
-		case ch <- message:
-		default:
-			// if the subscriber channel is blocked, skip it
+		trySend(ch, msg)
+	}
+}
+
+// JoinSync makes s receive the messages relayed between sync participants,
+// which other subscribers never see.
+func (b *Broadcaster) JoinSync(s Subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers[s] {
+		b.syncing[s] = true
+	}
+}
+
+// Relay sends a message from one subscriber to the other sync participants.
+// Only the current leader is relayed: a subscriber becomes the leader when
+// there is none or the previous one has been quiet for leaderTimeout. This
+// keeps followers replaying events from echoing them back.
+func (b *Broadcaster) Relay(from Subscriber, msg Message) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if b.leader != nil && b.leader != from && now.Sub(b.leaderSeen) < leaderTimeout {
+		return false
+	}
+	b.leader = from
+	b.leaderSeen = now
+
+	for ch := range b.syncing {
+		if ch != from {
+			trySend(ch, msg)
 		}
 	}
+	return true
+}
+
+// trySend sends msg unless the channel of the subscriber is full.
+func trySend(ch chan Message, msg Message) {
+	select {
+	case ch <- msg:
+	default:
+	}
 }
@@ -0,0 +1,56 @@
+package httpwatch
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRelayOnlyReachesSyncParticipants(t *testing.T) {
+	b := NewBroadcaster()
+	leader, follower, listener := b.AddSubscriber(), b.AddSubscriber(), b.AddSubscriber()
+	b.JoinSync(leader)
+	b.JoinSync(follower)
+
+	if !b.Relay(leader, Message{Type: "sync.scroll"}) {
+		t.Fatal("first relay was not accepted")
+	}
+	b.Broadcast(Message{Type: ActionFileChange})
+
+	tests := []struct {
+		name string
+		sub  Subscriber
+		want []string
+	}{
+		{"leader", leader, []string{ActionFileChange}},
+		{"follower", follower, []string{"sync.scroll", ActionFileChange}},
+		{"listener", listener, []string{ActionFileChange}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for len(tt.sub) > 0 {
+			got = append(got, (<-tt.sub).Type)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRelayFollowsLeader(t *testing.T) {
+	b := NewBroadcaster()
+	a, c := b.AddSubscriber(), b.AddSubscriber()
+	b.JoinSync(a)
+	b.JoinSync(c)
+
+	if !b.Relay(a, Message{Type: "sync.click"}) {
+		t.Fatal("relay from the first subscriber was rejected")
+	}
+	if b.Relay(c, Message{Type: "sync.click"}) {
+		t.Fatal("relay from a follower was accepted while the leader is active")
+	}
+
+	b.Remove(a)
+	if !b.Relay(c, Message{Type: "sync.click"}) {
+		t.Fatal("relay was rejected after the leader left")
+	}
+}
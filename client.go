@@ -0,0 +1,184 @@
+package httpwatch
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"html"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// ClientScriptPath is the path the browser client is served from.
+	ClientScriptPath = "/_/client.js"
+	// ClientLogPath is the path the browser client sends console errors to.
+	ClientLogPath = "/_/log"
+)
+
+//go:embed client.js
+var clientScript []byte
+
+// NewClientScriptHandler serves the browser client script.
+func NewClientScriptHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		_, _ = w.Write(clientScript)
+	}
+}
+
+type clientLogEntry struct {
+	Level   string `json:"level"`
+	Message string `json:"message"`
+	URL     string `json:"url"`
+	Stack   string `json:"stack"`
+}
+
+type ClientLogConfig struct {
+	// OriginPatterns are the origins besides the server's own allowed to send
+	// log entries, as for WebsocketConfig.OriginPatterns.
+	OriginPatterns []string
+}
+
+// NewClientLogHandler logs errors reported by the browser client. Entries
+// must be json, from pages of an allowed origin.
+func NewClientLogHandler(cfg ClientLogConfig) http.HandlerFunc {
+	const maxBodySize = 64 << 10
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		origin := r.Header.Get("Origin")
+		if !originAllowed(r, origin, cfg.OriginPatterns) {
+			slog.WarnContext(ctx, "rejected browser log entry", "reason", "origin not allowed", "origin", origin, "remote_addr", r.RemoteAddr)
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+			http.Error(w, "expected application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		var entry clientLogEntry
+		body := http.MaxBytesReader(w, r.Body, maxBodySize)
+		if err := json.NewDecoder(body).Decode(&entry); err != nil {
+			http.Error(w, "invalid log entry", http.StatusBadRequest)
+			return
+		}
+
+		level := slog.LevelError
+		if strings.EqualFold(entry.Level, "warn") {
+			level = slog.LevelWarn
+		}
+
+		attrs := []any{
+			"url", escapeControl(entry.URL),
+			"user_agent", r.UserAgent(),
+		}
+		if entry.Stack != "" {
+			attrs = append(attrs, "stack", escapeControl(entry.Stack))
+		}
+		slog.Log(ctx, level, "browser: "+escapeControl(entry.Message), attrs...)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// escapeControl quotes the control characters in s, such as newlines and ANSI
+// escape sequences, so pages can't forge log lines or restyle the terminal.
+func escapeControl(s string) string {
+	if !strings.ContainsFunc(s, unicode.IsControl) {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if unicode.IsControl(r) {
+			quoted := strconv.QuoteRune(r)
+			b.WriteString(quoted[1 : len(quoted)-1])
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+type injectResponseWriter struct {
+	http.ResponseWriter
+	snippet     []byte
+	buf         bytes.Buffer
+	status      int
+	wroteHeader bool
+	inject      bool
+}
+
+func (w *injectResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = code
+
+	contentType := w.Header().Get("Content-Type")
+	w.inject = code == http.StatusOK && strings.HasPrefix(contentType, "text/html")
+	if w.inject {
+		// the body grows once the snippet is added
+		w.Header().Del("Content-Length")
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *injectResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.inject {
+		return w.buf.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *injectResponseWriter) finish() error {
+	if !w.inject {
+		return nil
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+
+	body := w.buf.Bytes()
+	idx := bytes.LastIndex(bytes.ToLower(body), []byte("</body>"))
+	if idx == -1 {
+		idx = len(body)
+	}
+
+	if _, err := w.ResponseWriter.Write(body[:idx]); err != nil {
+		return err
+	}
+	if _, err := w.ResponseWriter.Write(w.snippet); err != nil {
+		return err
+	}
+	_, err := w.ResponseWriter.Write(body[idx:])
+	return err
+}
+
+// newInjectHandler adds a script tag loading the browser client to html
+// responses, passing token to it when set.
+func newInjectHandler(next http.Handler, token string) http.Handler {
+	src := ClientScriptPath
+	if token != "" {
+		src += "?token=" + url.QueryEscape(token)
+	}
+	snippet := []byte(`<script src="` + html.EscapeString(src) + `"></script>`)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		iw := &injectResponseWriter{ResponseWriter: w, snippet: snippet}
+		next.ServeHTTP(iw, r)
+		if err := iw.finish(); err != nil {
+			slog.DebugContext(r.Context(), "inject client script", "err", err)
+		}
+	})
+}
@@ -0,0 +1,256 @@
+// http-watch browser client, injected into html pages served by http-watch.
+(() => {
+  const base = "/_";
+  // token is required by the events endpoint when the server sets one
+  const token = new URL(document.currentScript.src).searchParams.get("token");
+
+  function stringify(value) {
+    if (value instanceof Error) {
+      return value.message;
+    }
+    if (typeof value === "string") {
+      return value;
+    }
+    try {
+      return JSON.stringify(value);
+    } catch {
+      return String(value);
+    }
+  }
+
+  // maxLogField keeps entries below the 64KB keepalive requests may send.
+  const maxLogField = 16 << 10;
+
+  // send forwards a log entry to the server so it shows up in the terminal.
+  // It never throws, errors here must not reach the page being debugged.
+  function send(level, message, stack) {
+    try {
+      const body = JSON.stringify({
+        level: level,
+        message: String(message).slice(0, maxLogField),
+        stack: (stack || "").slice(0, maxLogField),
+        url: location.href,
+      });
+      fetch(base + "/log", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: body,
+        keepalive: true,
+      }).catch(() => {});
+    } catch {
+      // nothing to report the failure to
+    }
+  }
+
+  const consoleError = console.error;
+  console.error = (...args) => {
+    consoleError.apply(console, args);
+    try {
+      const err = args.find((arg) => arg instanceof Error);
+      send("error", args.map(stringify).join(" "), err && err.stack);
+    } catch {
+      // e.g. arguments whose conversion to a string throws
+    }
+  };
+
+  window.addEventListener("error", (event) => {
+    try {
+      const err = event.error;
+      const message = event.message || stringify(err);
+      send("error", message, err && err.stack ? err.stack : `${event.filename}:${event.lineno}:${event.colno}`);
+    } catch {
+      // see console.error
+    }
+  });
+
+  window.addEventListener("unhandledrejection", (event) => {
+    try {
+      const reason = event.reason;
+      send("error", "unhandled rejection: " + stringify(reason), reason && reason.stack);
+    } catch {
+      // see console.error
+    }
+  });
+
+  // cssPath returns a selector that finds the same element on other clients.
+  function cssPath(el) {
+    const parts = [];
+    while (el && el.nodeType === Node.ELEMENT_NODE && el !== document.body) {
+      if (el.id) {
+        parts.unshift("#" + CSS.escape(el.id));
+        break;
+      }
+      const index = Array.prototype.indexOf.call(el.parentNode.children, el) + 1;
+      parts.unshift(`${el.localName}:nth-child(${index})`);
+      el = el.parentNode;
+    }
+    if (!parts.length || !parts[0].startsWith("#")) {
+      parts.unshift("body");
+    }
+    return parts.join(" > ");
+  }
+
+  let socket = null;
+  let onSync = null;
+
+  // enableSync relays navigation, scrolling, input and clicks to other clients
+  // and returns the handler for events received from them.
+  function enableSync() {
+    let applying = 0;
+    const sendSync = (type, data) => {
+      if (Date.now() - applying < 100 || socket.readyState !== WebSocket.OPEN) {
+        return;
+      }
+      socket.send(JSON.stringify({ type: type, data: data }));
+    };
+    const here = () => location.pathname + location.search + location.hash;
+
+    sendSync("sync.navigate", { url: here() });
+
+    let scrollPending = false;
+    window.addEventListener("scroll", () => {
+      if (scrollPending) {
+        return;
+      }
+      scrollPending = true;
+      setTimeout(() => {
+        scrollPending = false;
+        const el = document.documentElement;
+        sendSync("sync.scroll", {
+          x: window.scrollX / Math.max(el.scrollWidth - el.clientWidth, 1),
+          y: window.scrollY / Math.max(el.scrollHeight - el.clientHeight, 1),
+        });
+      }, 50);
+    });
+
+    document.addEventListener("input", (event) => {
+      const el = event.target;
+      if (!event.isTrusted || !("value" in el)) {
+        return;
+      }
+      sendSync("sync.input", { selector: cssPath(el), value: el.value, checked: !!el.checked });
+    }, true);
+
+    document.addEventListener("click", (event) => {
+      if (event.isTrusted) {
+        sendSync("sync.click", { selector: cssPath(event.target) });
+      }
+    }, true);
+
+    return (msg) => {
+      const data = msg.data || {};
+      applying = Date.now();
+      switch (msg.type) {
+        case "sync.navigate": {
+          if (!data.url) {
+            break;
+          }
+          // only follow pages of this server, never other sites or javascript: urls
+          const u = new URL(data.url, location.href);
+          if (u.origin === location.origin && u.pathname + u.search + u.hash !== here()) {
+            location.assign(u.href);
+          }
+          break;
+        }
+        case "sync.scroll": {
+          const el = document.documentElement;
+          window.scrollTo(
+            data.x * (el.scrollWidth - el.clientWidth),
+            data.y * (el.scrollHeight - el.clientHeight),
+          );
+          break;
+        }
+        case "sync.input": {
+          const el = document.querySelector(data.selector);
+          if (el) {
+            el.value = data.value;
+            el.checked = data.checked;
+            el.dispatchEvent(new Event("input", { bubbles: true }));
+          }
+          break;
+        }
+        case "sync.click": {
+          const el = document.querySelector(data.selector);
+          if (el) {
+            el.click();
+          }
+          break;
+        }
+      }
+    };
+  }
+
+  // matches reports if the url of an element points at the changed file.
+  function matches(url, path) {
+    try {
+      return new URL(url, location.href).pathname === path;
+    } catch {
+      return false;
+    }
+  }
+
+  // bust returns url with a query parameter forcing the browser to refetch it.
+  function bust(url, hash) {
+    const u = new URL(url, location.href);
+    u.searchParams.set("_hw", hash || Date.now());
+    return u.toString();
+  }
+
+  // updateCSS swaps changed stylesheets without reloading the page, or all of
+  // them when the changed file is not linked directly (e.g. an @import).
+  function updateCSS(change) {
+    const links = Array.from(document.querySelectorAll('link[rel="stylesheet"]'));
+    const changed = links.filter((link) => matches(link.href, change.path));
+    for (const link of changed.length ? changed : links) {
+      const next = link.cloneNode();
+      next.href = bust(link.href, change.hash);
+      next.addEventListener("load", () => link.remove());
+      next.addEventListener("error", () => link.remove());
+      link.after(next);
+    }
+  }
+
+  // updateAssets refreshes images using the changed file.
+  function updateAssets(change) {
+    for (const img of document.images) {
+      if (matches(img.src, change.path)) {
+        img.src = bust(img.src, change.hash);
+      }
+    }
+  }
+
+  function connect(delay) {
+    const proto = location.protocol === "https:" ? "wss:" : "ws:";
+    const protocols = token ? ["http-watch.token." + token] : [];
+    const ws = new WebSocket(`${proto}//${location.host}${base}/events`, protocols);
+    let connected = false;
+    socket = ws;
+
+    ws.addEventListener("open", () => {
+      connected = true;
+    });
+    ws.addEventListener("message", (event) => {
+      const msg = JSON.parse(event.data);
+      if (msg.type === "file.change" || msg.type === "page.reload") {
+        location.reload();
+      } else if (msg.type === "css.update") {
+        updateCSS(msg.data);
+      } else if (msg.type === "asset.update") {
+        updateAssets(msg.data);
+      } else if (msg.type === "sync.enabled") {
+        onSync = onSync || enableSync();
+      } else if (onSync && msg.type.startsWith("sync.")) {
+        onSync(msg);
+      }
+    });
+    ws.addEventListener("close", () => {
+      // stop when the first attempt fails, e.g. the page is not served by http-watch
+      if (!connected && delay === 0) {
+        return;
+      }
+      setTimeout(() => connect(Math.min((delay || 500) * 2, 5000)), delay || 500);
+    });
+  }
+
+  connect(0);
+})();
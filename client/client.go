@@ -0,0 +1,205 @@
+// Package client consumes the events emitted by an http-watch server.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+const (
+	minBackoff   = 500 * time.Millisecond
+	maxBackoff   = 30 * time.Second
+	pingInterval = 30 * time.Second
+	pingTimeout  = 10 * time.Second
+)
+
+// Event is a message received from the server.
+type Event struct {
+	// ID is set for broadcast events and is used to replay missed events
+	// after a reconnect.
+	ID   uint64          `json:"id,omitempty"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+type fileChange struct {
+	Root string `json:"root"`
+	Path string `json:"path"`
+	Op   string `json:"op"`
+	Hash string `json:"hash"`
+}
+
+func (e Event) fileChange() fileChange {
+	var change fileChange
+	_ = json.Unmarshal(e.Data, &change)
+	return change
+}
+
+// Root returns the watched directory of file.change events.
+func (e Event) Root() string {
+	return e.fileChange().Root
+}
+
+// Path returns the changed file, relative to Root, for file.change events.
+func (e Event) Path() string {
+	return e.fileChange().Path
+}
+
+// Op returns "create", "write" or "remove" for file.change events.
+func (e Event) Op() string {
+	return e.fileChange().Op
+}
+
+// Hash returns the content hash of the changed file for file.change events,
+// empty when the file was removed.
+func (e Event) Hash() string {
+	return e.fileChange().Hash
+}
+
+// Client receives events from the server's websocket endpoint and
+// reconnects with backoff until it is closed.
+type Client struct {
+	url    string
+	events chan Event
+	cancel context.CancelFunc
+	done   chan struct{}
+	lastID uint64
+}
+
+// Dial connects to the events endpoint at url, e.g. ws://localhost:8080/_/events.
+// Servers requiring a token take it as query parameter: /_/events?token=...
+func Dial(ctx context.Context, url string) (*Client, error) {
+	c := &Client{
+		url:    url,
+		events: make(chan Event, 16),
+		done:   make(chan struct{}),
+	}
+
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	go c.run(runCtx, conn)
+	return c, nil
+}
+
+// Events returns the channel events are delivered on. It is closed once
+// the client is closed.
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+// Close stops the client and closes the events channel.
+func (c *Client) Close() error {
+	c.cancel()
+	<-c.done
+	return nil
+}
+
+func (c *Client) dial(ctx context.Context) (*websocket.Conn, error) {
+	u, err := url.Parse(c.url)
+	if err != nil {
+		return nil, fmt.Errorf("url.Parse: %w", err)
+	}
+	if c.lastID > 0 {
+		q := u.Query()
+		q.Set("since", strconv.FormatUint(c.lastID, 10))
+		u.RawQuery = q.Encode()
+	}
+
+	conn, _, err := websocket.Dial(ctx, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("websocket.Dial: %w", err)
+	}
+	return conn, nil
+}
+
+func (c *Client) run(ctx context.Context, conn *websocket.Conn) {
+	defer close(c.done)
+	defer close(c.events)
+
+	backoff := minBackoff
+	for {
+		err := c.read(ctx, conn)
+		_ = conn.CloseNow()
+		if ctx.Err() != nil {
+			return
+		}
+		slog.DebugContext(ctx, "client connection lost", "err", err)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			conn, err = c.dial(ctx)
+			if err == nil {
+				backoff = minBackoff
+				break
+			}
+			slog.DebugContext(ctx, "client reconnect failed", "err", err, "backoff", backoff)
+			backoff = min(backoff*2, maxBackoff)
+		}
+	}
+}
+
+// read delivers events from conn until the connection fails.
+func (c *Client) read(ctx context.Context, conn *websocket.Conn) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Pings are answered while reading, sending our own detects a server
+	// that went away without closing the connection.
+	go func() {
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+				err := conn.Ping(pingCtx)
+				cancel()
+				if err != nil {
+					_ = conn.CloseNow()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			return err
+		}
+
+		var event Event
+		if err := json.Unmarshal(data, &event); err != nil {
+			return fmt.Errorf("json.Unmarshal: %w", err)
+		}
+		if event.ID > 0 {
+			c.lastID = event.ID
+		}
+
+		select {
+		case c.events <- event:
+		case <-ctx.Done():
+			return errors.Join(ctx.Err(), conn.Close(websocket.StatusNormalClosure, ""))
+		}
+	}
+}
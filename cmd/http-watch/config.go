@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultConfigFile = "http-watch.json"
+	envPrefix         = "HTTPWATCH_"
+)
+
+// repeatableFlag is implemented by flags that may be given more than once,
+// which accept a list in the config file.
+type repeatableFlag interface {
+	repeatable()
+}
+
+// envName returns the environment variable for a flag, e.g.
+// HTTPWATCH_LOG_LEVEL for -log.level.
+func envName(flagName string) string {
+	name := strings.NewReplacer(".", "_", "-", "_").Replace(flagName)
+	return envPrefix + strings.ToUpper(name)
+}
+
+// applyConfigSources fills in the flags not given on the command line, first
+// from HTTPWATCH_* environment variables and then from the config file.
+// configPath is the -config flag, when empty http-watch.json in the working
+// directory is used if it exists.
+func applyConfigSources(fs *flag.FlagSet, configPath *string) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	if !set["config"] {
+		if path, ok := os.LookupEnv(envName("config")); ok {
+			*configPath = path
+		}
+	}
+	path, fileValues, err := readConfigFile(fs, *configPath)
+	if err != nil {
+		return err
+	}
+	*configPath = path
+
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || f.Name == "config" {
+			return
+		}
+
+		if value, ok := os.LookupEnv(envName(f.Name)); ok {
+			if err := f.Value.Set(value); err != nil {
+				errs = append(errs, fmt.Errorf("invalid value %q for %s: %w", value, envName(f.Name), err))
+			}
+			return
+		}
+
+		for _, value := range fileValues[f.Name] {
+			if err := f.Value.Set(value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid value %q for %q: %w", path, value, f.Name, err))
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// readConfigFile reads a json object whose keys are flag names, returning
+// the path read and the values as flag strings. Repeatable flags take a list
+// of values.
+func readConfigFile(fs *flag.FlagSet, path string) (string, map[string][]string, error) {
+	if path == "" {
+		if _, err := os.Stat(defaultConfigFile); errors.Is(err, os.ErrNotExist) {
+			return "", nil, nil
+		}
+		path = defaultConfigFile
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return path, nil, err
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return path, nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	values := map[string][]string{}
+	var errs []error
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		f := fs.Lookup(key)
+		if f == nil || key == "config" {
+			errs = append(errs, fmt.Errorf("%s: unknown key %q", path, key))
+			continue
+		}
+
+		var value any
+		if err := json.Unmarshal(raw[key], &value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %q: %w", path, key, err))
+			continue
+		}
+
+		list, isList := value.([]any)
+		if !isList {
+			list = []any{value}
+		} else if _, ok := f.Value.(repeatableFlag); !ok {
+			errs = append(errs, fmt.Errorf("%s: %q takes a single value, not a list", path, key))
+			continue
+		}
+
+		for _, v := range list {
+			str, err := configValueString(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q: %w", path, key, err))
+				continue
+			}
+			values[key] = append(values[key], str)
+		}
+	}
+	return path, values, errors.Join(errs...)
+}
+
+func configValueString(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", v)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name        string
+		file        string
+		env         map[string]string
+		args        []string
+		wantPattern string
+		wantGzip    bool
+		wantWatches []string
+	}{
+		{
+			name:        "defaults",
+			file:        `{}`,
+			wantPattern: "",
+			wantGzip:    true,
+		},
+		{
+			name:        "file",
+			file:        `{"pattern": "file", "gzip": false, "watch": ["a", "b"]}`,
+			wantPattern: "file",
+			wantGzip:    false,
+			wantWatches: []string{"a", "b"},
+		},
+		{
+			name:        "env over file",
+			file:        `{"pattern": "file", "gzip": false, "watch": ["a", "b"]}`,
+			env:         map[string]string{"HTTPWATCH_PATTERN": "env", "HTTPWATCH_GZIP": "true", "HTTPWATCH_WATCH": "c"},
+			wantPattern: "env",
+			wantGzip:    true,
+			wantWatches: []string{"c"},
+		},
+		{
+			name:        "flag over env and file",
+			file:        `{"pattern": "file", "gzip": true, "watch": ["a", "b"]}`,
+			env:         map[string]string{"HTTPWATCH_PATTERN": "env", "HTTPWATCH_GZIP": "true"},
+			args:        []string{"-pattern=flag", "-gzip=false", "-watch=d"},
+			wantPattern: "flag",
+			wantGzip:    false,
+			wantWatches: []string{"d"},
+		},
+		{
+			name:        "unset keys fall through",
+			file:        `{"pattern": "file", "gzip": false}`,
+			env:         map[string]string{"HTTPWATCH_WATCH": "c"},
+			args:        []string{"-pattern=flag"},
+			wantPattern: "flag",
+			wantGzip:    false,
+			wantWatches: []string{"c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "http-watch.json")
+			if err := os.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			cfg, err := loadConfig(append([]string{"-config=" + path}, tt.args...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.FilePattern != tt.wantPattern {
+				t.Errorf("pattern: got %q, want %q", cfg.FilePattern, tt.wantPattern)
+			}
+			if cfg.gzip != tt.wantGzip {
+				t.Errorf("gzip: got %v, want %v", cfg.gzip, tt.wantGzip)
+			}
+			if !slices.Equal(cfg.watches, tt.wantWatches) {
+				t.Errorf("watch: got %q, want %q", cfg.watches, tt.wantWatches)
+			}
+		})
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+	}{
+		{name: "file value", file: `{"log.level": "loud"}`},
+		{name: "env value", file: `{}`, env: map[string]string{"HTTPWATCH_LIMIT_RATE": "fast"}},
+		{name: "unknown key", file: `{"no-such-flag": true}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "http-watch.json")
+			if err := os.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			if _, err := loadConfig([]string{"-config=" + path}); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
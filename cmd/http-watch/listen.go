@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+
+	"github.com/zhamlin/http-watch/client"
+)
+
+type listenConfig struct {
+	url      string
+	logLevel slog.Level
+	json     bool
+	// command is run for every file event, with {} replaced by the path.
+	command []string
+}
+
+func loadListenConfig(args []string) listenConfig {
+	cfg := listenConfig{}
+	fs := flag.NewFlagSet("listen", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s listen [flags] [-- command {}]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&cfg.url, "url", "ws://localhost:8080/_/events", "events endpoint to connect to")
+	fs.TextVar(&cfg.logLevel, "log.level", slog.LevelInfo, "slog log level to use: debug, info, warn or error")
+	fs.BoolVar(&cfg.json, "json", false, "print events as json")
+
+	_ = fs.Parse(args)
+	cfg.command = fs.Args()
+	return cfg
+}
+
+func runListen(ctx context.Context, cfg listenConfig) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	c, err := client.Dial(ctx, cfg.url)
+	if err != nil {
+		return fmt.Errorf("client.Dial: %w", err)
+	}
+	defer c.Close()
+
+	slog.InfoContext(ctx, "listening for events", "url", cfg.url)
+	enc := json.NewEncoder(os.Stdout)
+	for {
+		var event client.Event
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-c.Events():
+			if !ok {
+				return nil
+			}
+			event = e
+		}
+
+		path := event.Path()
+		if cfg.json {
+			if err := enc.Encode(event); err != nil {
+				return fmt.Errorf("json.Encode: %w", err)
+			}
+		} else if path != "" {
+			fmt.Println(path)
+		}
+
+		if path != "" && len(cfg.command) > 0 {
+			runCommand(ctx, cfg.command, path)
+		}
+	}
+}
+
+// runCommand runs the command with every {} argument replaced by path.
+func runCommand(ctx context.Context, command []string, path string) {
+	args := make([]string, len(command))
+	for i, arg := range command {
+		args[i] = strings.ReplaceAll(arg, "{}", path)
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		slog.ErrorContext(ctx, "command failed", "cmd", args, "err", err)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	defaultAddr = "localhost:8080"
+	// listenFDsStart is the first file descriptor passed with the systemd
+	// LISTEN_FDS protocol.
+	listenFDsStart = 3
+)
+
+const addrFlagUsage = "address to listen on: host:port, tcp://host:port, unix:///path.sock, " +
+	"fd:// for all sockets passed via LISTEN_FDS or fd://N for a single one (repeatable, default " + defaultAddr + ")"
+
+// openListeners opens a listener for every address, closing the opened ones
+// when one fails. Inherited sockets that are used are removed from inherited.
+// Ports in use are retried with up to fallback following ports.
+func openListeners(addrs []string, inherited map[int]net.Listener, fallback int) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, addr := range addrs {
+		ls, err := openListener(addr, inherited, fallback)
+		if err != nil {
+			closeListeners(listeners...)
+			return nil, fmt.Errorf("listen on %q: %w", addr, err)
+		}
+		listeners = append(listeners, ls...)
+	}
+	return listeners, nil
+}
+
+func closeListeners(listeners ...net.Listener) {
+	for _, l := range listeners {
+		_ = l.Close()
+	}
+}
+
+// openListener opens the listeners of addr. Inherited sockets that are used
+// are removed from inherited.
+func openListener(addr string, inherited map[int]net.Listener, fallback int) ([]net.Listener, error) {
+	network, address, ok := strings.Cut(addr, "://")
+	if !ok {
+		network, address = "tcp", addr
+	}
+
+	switch network {
+	case "tcp":
+		return listenAll(listenTCP(address, fallback))
+	case "unix":
+		if err := removeStaleSocket(address); err != nil {
+			return nil, err
+		}
+		return listenAll(net.Listen("unix", address))
+	case "fd":
+		if address == "" {
+			if len(inherited) == 0 {
+				return nil, errors.New("no unused sockets passed via LISTEN_FDS")
+			}
+			listeners := make([]net.Listener, 0, len(inherited))
+			for fd := listenFDsStart; len(inherited) > 0; fd++ {
+				if l, ok := inherited[fd]; ok {
+					listeners = append(listeners, l)
+					delete(inherited, fd)
+				}
+			}
+			return listeners, nil
+		}
+
+		fd, err := strconv.Atoi(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file descriptor: %w", err)
+		}
+		l, ok := inherited[fd]
+		if !ok {
+			return nil, fmt.Errorf("fd %d was not passed via LISTEN_FDS", fd)
+		}
+		delete(inherited, fd)
+		return []net.Listener{l}, nil
+	}
+	return nil, fmt.Errorf("unsupported network %q", network)
+}
+
+// listenTCP listens on address, trying up to fallback following ports when
+// its port is in use.
+func listenTCP(address string, fallback int) (net.Listener, error) {
+	l, err := net.Listen("tcp", address)
+	if fallback <= 0 || !errors.Is(err, syscall.EADDRINUSE) {
+		return l, err
+	}
+
+	host, portStr, splitErr := net.SplitHostPort(address)
+	port, convErr := strconv.Atoi(portStr)
+	if splitErr != nil || convErr != nil || port == 0 {
+		return nil, err
+	}
+	for next := port + 1; next <= min(port+fallback, 65535); next++ {
+		l, nextErr := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(next)))
+		if nextErr == nil {
+			slog.Warn("port in use, using a fallback port", "addr", address, "port", next)
+			return l, nil
+		}
+		if !errors.Is(nextErr, syscall.EADDRINUSE) {
+			return nil, nextErr
+		}
+	}
+	return nil, err
+}
+
+func listenAll(l net.Listener, err error) ([]net.Listener, error) {
+	if err != nil {
+		return nil, err
+	}
+	return []net.Listener{l}, nil
+}
+
+// removeStaleSocket removes the unix socket at path when no server accepts
+// connections on it anymore, e.g. after a crash.
+func removeStaleSocket(path string) error {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("%s is in use", path)
+	}
+	return os.Remove(path)
+}
+
+// inheritedListeners returns the sockets passed with the systemd LISTEN_FDS
+// protocol by their file descriptor.
+func inheritedListeners() (map[int]net.Listener, error) {
+	defer func() {
+		_ = os.Unsetenv("LISTEN_PID")
+		_ = os.Unsetenv("LISTEN_FDS")
+		_ = os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_FDS: %w", err)
+	}
+
+	listeners := make(map[int]net.Listener, count)
+	for fd := listenFDsStart; fd < listenFDsStart+count; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		l, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return nil, fmt.Errorf("fd %d: net.FileListener: %w", fd, err)
+		}
+		listeners[fd] = l
+	}
+	return listeners, nil
+}
+
+// newRedirectHandler redirects requests to https on port, or the default
+// port when port is empty.
+func newRedirectHandler(port string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+
+		u := *r.URL
+		u.Scheme = "https"
+		u.Host = host
+		http.Redirect(w, r, u.String(), http.StatusPermanentRedirect)
+	}
+}
+
+// tcpPort returns the port of the first tcp listener, empty when there is
+// none.
+func tcpPort(listeners []net.Listener) string {
+	for _, l := range listeners {
+		if addr, ok := l.Addr().(*net.TCPAddr); ok {
+			return strconv.Itoa(addr.Port)
+		}
+	}
+	return ""
+}
+
+// listenerURL returns the url the server is reachable at via l, using
+// localhost for listeners on all interfaces.
+func listenerURL(l net.Listener, useTLS bool) string {
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+
+	switch addr := l.Addr().(type) {
+	case *net.TCPAddr:
+		host := addr.IP.String()
+		if addr.IP.IsUnspecified() {
+			host = "localhost"
+		}
+		return scheme + "://" + net.JoinHostPort(host, strconv.Itoa(addr.Port))
+	case *net.UnixAddr:
+		return "unix://" + addr.Name
+	}
+	return l.Addr().Network() + "://" + l.Addr().String()
+}
+
+// writeURLs prints the urls as json to stdout when asJSON is set and writes
+// them to file, one per line or as json, when file is set.
+func writeURLs(urls []string, file string, asJSON bool) error {
+	data, err := json.Marshal(struct {
+		URLs []string `json:"urls"`
+	}{urls})
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+	data = append(data, '\n')
+	if asJSON {
+		if _, err := os.Stdout.Write(data); err != nil {
+			return fmt.Errorf("os.Stdout.Write: %w", err)
+		}
+	}
+
+	if file == "" {
+		return nil
+	}
+	if !asJSON {
+		data = []byte(strings.Join(urls, "\n") + "\n")
+	}
+	if err := os.WriteFile(file, data, 0o644); err != nil {
+		return fmt.Errorf("os.WriteFile: %w", err)
+	}
+	return nil
+}
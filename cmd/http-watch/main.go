@@ -2,14 +2,18 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"regexp/syntax"
+	"slices"
 	"strings"
 	"time"
 
@@ -19,48 +23,284 @@ import (
 
 type config struct {
 	httpwatch.WatcherConfig
-	addr     string
-	logLevel string
-	tlsCert  string
-	tlsKey   string
-	gzip     bool
+	configPath   string
+	addrs        stringsFlag
+	portFallback int
+	urlFile      string
+	urlJSON      bool
+	logLevel     slog.Level
+	debugWatch   bool
+	tlsCert      string
+	tlsKey       string
+	tlsAuto      bool
+	tlsRedirect  string
+	h2c          bool
+	timeouts     timeouts
+	gzip         bool
+	client       bool
+	sync         bool
+	wsOrigins    stringsFlag
+	wsToken      string
+	htpasswd     string
+	authToken    bool
+	loginToken   string
+	dotfiles     bool
+	deny         globsFlag
+	allow        globsFlag
+	cors         corsConfig
+	wsPerIP      int
+	rateLimit    httpwatch.RateLimitConfig
+	watches      stringsFlag
+	headers      headersFlag
+	// values holds every flag's final value, used to log config changes
+	values map[string]string
+}
+
+// responseHeaders returns the default headers set on served files, with
+// the ones from -header flags replacing them.
+func (c config) responseHeaders() http.Header {
+	headers := http.Header{}
+	headers.Set("Cross-Origin-Opener-Policy", "same-origin")
+	headers.Set("Cross-Origin-Embedder-Policy", "require-corp")
+	headers.Set("Cache-Control", "max-age=0")
+
+	for key, values := range c.headers.headers {
+		headers[key] = values
+	}
+	return headers
+}
+
+// timeouts are the http.Server timeouts, zero disables one.
+type timeouts struct {
+	read       time.Duration
+	readHeader time.Duration
+	write      time.Duration
+	idle       time.Duration
+}
+
+// wsOriginPatterns returns the origins allowed to connect to the events
+// endpoint: the -ws.origin hosts and the CORS origins, except for "*".
+func (c config) wsOriginPatterns() []string {
+	patterns := slices.Clone(c.wsOrigins)
+	for _, origin := range c.cors.origins {
+		if origin != "*" {
+			patterns = append(patterns, origin)
+		}
+	}
+	return patterns
 }
 
 func (c config) hasTLS() bool {
 	return c.tlsKey != "" && c.tlsCert != ""
 }
 
-func loadConfig() config {
-	cfg := config{}
-	flag.StringVar(&cfg.addr, "addr", "localhost:8080", "address to listen on")
-	flag.StringVar(&cfg.Dir, "dir", "", "directory to serve via /")
-	flag.StringVar(&cfg.FilePattern, "pattern", "", "file matching pattern")
-	flag.StringVar(&cfg.logLevel, "log.level", "info", "slog log level to use")
-	flag.StringVar(&cfg.tlsCert, "tls.cert", "", "tls cert")
-	flag.StringVar(&cfg.tlsKey, "tls.key", "", "tls key")
-	flag.BoolVar(&cfg.Recursive, "recursive", true, "watch all files recursively")
-	flag.BoolVar(&cfg.gzip, "gzip", true, "Use gzip compression")
-
-	flag.Parse()
+// watchers returns the config of the -dir watcher, when a pattern is set,
+// followed by one for every -watch flag.
+func (c config) watchers() []httpwatch.WatcherConfig {
+	var watchers []httpwatch.WatcherConfig
+	if c.FilePattern != "" || len(c.Rules) > 0 {
+		watchers = append(watchers, c.WatcherConfig)
+	}
+	for _, spec := range c.watches {
+		watchers = append(watchers, parseWatchSpec(spec, c.WatcherConfig))
+	}
+	return watchers
+}
+
+// stringsFlag collects the values of a repeatable flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func (f *stringsFlag) repeatable() {}
+
+// corsConfig holds the CORS flags.
+type corsConfig struct {
+	origins     stringsFlag
+	methods     stringsFlag
+	headers     stringsFlag
+	credentials bool
+	maxAge      time.Duration
+}
+
+// globsFlag is a repeatable flag of httpwatch.MatchGlob patterns.
+type globsFlag []string
+
+func (f *globsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *globsFlag) Set(value string) error {
+	if err := httpwatch.ValidGlob(value); err != nil {
+		return err
+	}
+	*f = append(*f, value)
+	return nil
+}
+
+func (f *globsFlag) repeatable() {}
+
+// rulesFlag parses repeatable pattern=action flags into watcher rules.
+type rulesFlag struct {
+	rules *[]httpwatch.Rule
+}
+
+func (f rulesFlag) String() string {
+	if f.rules == nil {
+		return ""
+	}
+	var rules []string
+	for _, r := range *f.rules {
+		rules = append(rules, r.Pattern+"="+r.Action)
+	}
+	return strings.Join(rules, ",")
+}
+
+func (f rulesFlag) repeatable() {}
+
+func (f rulesFlag) Set(value string) error {
+	// the action is after the last =, patterns may contain one
+	i := strings.LastIndex(value, "=")
+	if i == -1 || i == len(value)-1 {
+		return errors.New("expected pattern=action")
+	}
+	*f.rules = append(*f.rules, httpwatch.Rule{Pattern: value[:i], Action: value[i+1:]})
+	return nil
+}
+
+// headersFlag parses repeatable "Key: Value" flags.
+type headersFlag struct {
+	headers http.Header
+}
+
+func (f *headersFlag) String() string {
+	var headers []string
+	for key, values := range f.headers {
+		for _, value := range values {
+			headers = append(headers, key+": "+value)
+		}
+	}
+	slices.Sort(headers)
+	return strings.Join(headers, ",")
+}
+
+func (f *headersFlag) Set(value string) error {
+	key, value, ok := strings.Cut(value, ":")
+	if !ok || strings.TrimSpace(key) == "" {
+		return errors.New(`expected "Key: Value"`)
+	}
+	if f.headers == nil {
+		f.headers = http.Header{}
+	}
+	f.headers.Add(strings.TrimSpace(key), strings.TrimSpace(value))
+	return nil
+}
+
+func (f *headersFlag) repeatable() {}
+
+const watchFlagUsage = "additional path[:pattern] to watch, recursively when the path ends in /... (repeatable)"
+
+// parseWatchSpec parses a -watch value of the form path[:pattern]. Paths
+// ending in /... are watched recursively, like go package patterns. The
+// backend settings are copied from base.
+func parseWatchSpec(spec string, base httpwatch.WatcherConfig) httpwatch.WatcherConfig {
+	cfg := base
+	cfg.Dir, cfg.FilePattern, _ = strings.Cut(spec, ":")
+	cfg.Recursive = false
+
+	if dir, ok := strings.CutSuffix(cfg.Dir, "..."); ok {
+		cfg.Dir = dir
+		cfg.Recursive = true
+	}
+	if cfg.Dir = strings.TrimSuffix(cfg.Dir, "/"); cfg.Dir == "" {
+		cfg.Dir = "."
+	}
 	return cfg
 }
 
-func strToLogLevel(str string) slog.Level {
-	switch strings.ToUpper(str) {
-	case slog.LevelError.String():
-		return slog.LevelError
-	case slog.LevelWarn.String():
-		return slog.LevelWarn
-	case slog.LevelInfo.String():
-		return slog.LevelInfo
-	case slog.LevelDebug.String():
-		return slog.LevelDebug
+func loadConfig(args []string) (config, error) {
+	cfg := config{}
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	fs.StringVar(&cfg.configPath, "config", "", "config file (default "+defaultConfigFile+" if present)")
+	fs.Var(&cfg.addrs, "addr", addrFlagUsage)
+	fs.IntVar(&cfg.portFallback, "port-fallback", 0, "try up to this many following ports when the port of an -addr is in use")
+	fs.StringVar(&cfg.urlFile, "url.file", "", "write the urls the server listens on to this file, one per line or as json with -url.json")
+	fs.BoolVar(&cfg.urlJSON, "url.json", false, `print the urls the server listens on as json, {"urls": [...]}, to stdout`)
+	fs.StringVar(&cfg.Dir, "dir", "", "directory to serve via /")
+	fs.StringVar(&cfg.FilePattern, "pattern", "", "file matching pattern")
+	fs.TextVar(&cfg.logLevel, "log.level", slog.LevelInfo, "slog log level to use: debug, info, warn or error")
+	fs.BoolVar(&cfg.debugWatch, "debug.watch", false, "serve the state of the running watchers as json at /_/debug/watch")
+	fs.StringVar(&cfg.tlsCert, "tls.cert", "", "tls cert")
+	fs.StringVar(&cfg.tlsKey, "tls.key", "", "tls key")
+	fs.BoolVar(&cfg.tlsAuto, "tls.auto", false, "serve https with a certificate signed by a generated local CA, unless -tls.cert and -tls.key are set")
+	fs.StringVar(&cfg.tlsRedirect, "tls.redirect", "", "address to listen on for http requests to redirect to https, when serving https")
+	fs.BoolVar(&cfg.h2c, "h2c", false, "accept HTTP/2 without TLS (h2c) from clients with prior knowledge")
+	fs.DurationVar(&cfg.timeouts.read, "timeout.read", 0, "maximum duration for reading a request including the body, 0 for none")
+	fs.DurationVar(&cfg.timeouts.readHeader, "timeout.read-header", 10*time.Second, "maximum duration for reading request headers, 0 for none")
+	fs.DurationVar(&cfg.timeouts.write, "timeout.write", 0, "maximum duration for writing a response, 0 for none; websockets are exempt")
+	fs.DurationVar(&cfg.timeouts.idle, "timeout.idle", 20*time.Second, "maximum duration to keep idle keep-alive connections open, 0 uses -timeout.read")
+	fs.BoolVar(&cfg.Recursive, "recursive", true, "watch all files recursively")
+	fs.BoolVar(&cfg.FollowSymlinks, "follow-symlinks", false, "watch symlinked directories when recursive")
+	fs.Var(&cfg.watches, "watch", watchFlagUsage)
+	fs.Var(rulesFlag{&cfg.Rules}, "rule", "pattern=action sending matching changes as the action (css.update, asset.update, page.reload, build), repeatable")
+	backendFlags(fs, &cfg.WatcherConfig)
+	fs.BoolVar(&cfg.gzip, "gzip", true, "Use gzip compression")
+	fs.BoolVar(&cfg.dotfiles, "dotfiles", false, "serve files and directories starting with a dot, hidden by default")
+	fs.Var(&cfg.deny, "deny", "hide files matching this glob, e.g. *.key or secrets/** (repeatable)")
+	fs.Var(&cfg.allow, "allow", "serve files matching this glob even when hidden by -deny or as dotfile, e.g. .well-known (repeatable)")
+	fs.Var(&cfg.cors.origins, "cors.origin", `origin allowed to make cross-origin requests, e.g. http://localhost:*, "*" for any (repeatable, default "*")`)
+	fs.Var(&cfg.cors.methods, "cors.method", "method allowed in cross-origin requests (repeatable, default GET and HEAD)")
+	fs.Var(&cfg.cors.headers, "cors.header", `request header allowed in cross-origin requests, "*" for any (repeatable)`)
+	fs.BoolVar(&cfg.cors.credentials, "cors.credentials", false, "allow cookies and authorization in cross-origin requests")
+	fs.DurationVar(&cfg.cors.maxAge, "cors.max-age", 10*time.Minute, "how long browsers may cache preflight responses")
+	fs.IntVar(&cfg.wsPerIP, "limit.ws-per-ip", 32, "maximum concurrent /_/events connections per client IP, 0 for no limit")
+	fs.Float64Var(&cfg.rateLimit.Rate, "limit.rate", 0, "requests per second per client IP allowed, 0 for no limit")
+	fs.IntVar(&cfg.rateLimit.Burst, "limit.burst", 0, "requests per client IP allowed at once with -limit.rate, defaults to the rate")
+	fs.BoolVar(&cfg.client, "client", true, "inject the browser client into html pages")
+	fs.BoolVar(&cfg.sync, "sync", false, "sync navigation, scrolling, input and clicks between clients")
+	fs.Var(&cfg.wsOrigins, "ws.origin", "host pattern, e.g. *.example.test, of pages allowed to connect to /_/events besides the server's own (repeatable)")
+	fs.StringVar(&cfg.wsToken, "ws.token", "", "token required to connect to /_/events, via ?token= or the http-watch.token.<token> subprotocol")
+	fs.StringVar(&cfg.htpasswd, "auth.htpasswd", "", "require basic auth with the users of this htpasswd file ({SHA}, $5$ or $6$ hashes)")
+	fs.BoolVar(&cfg.authToken, "auth.token", false, "require sign in with a one-time login token printed at startup, which sets a session cookie")
+	fs.Var(&cfg.headers, "header", `"Key: Value" response header for served files, replacing the default for Key (repeatable)`)
+
+	_ = fs.Parse(args)
+	if err := applyConfigSources(fs, &cfg.configPath); err != nil {
+		return cfg, err
+	}
+
+	if len(cfg.addrs) == 0 {
+		cfg.addrs = stringsFlag{defaultAddr}
+	}
+	if len(cfg.cors.origins) == 0 {
+		cfg.cors.origins = stringsFlag{"*"}
 	}
-	panic("invalid slog log level: " + str)
+
+	cfg.values = map[string]string{}
+	fs.VisitAll(func(f *flag.Flag) {
+		cfg.values[f.Name] = f.Value.String()
+	})
+	return cfg, nil
+}
+
+// backendFlags registers the flags selecting the watcher backend.
+func backendFlags(fs *flag.FlagSet, cfg *httpwatch.WatcherConfig) {
+	fs.StringVar(&cfg.Backend, "watcher", httpwatch.BackendAuto, "watcher backend: auto, fsnotify or poll")
+	fs.DurationVar(&cfg.PollInterval, "poll.interval", 500*time.Millisecond, "how often the poll watcher scans for changes")
+	fs.BoolVar(&cfg.PollHash, "poll.hash", false, "compare file contents when polling, not just mtime and size")
 }
 
+// logLevel is the level of the default logger, changed on config reloads.
+var logLevel slog.LevelVar
+
 func setupSlog(level slog.Level) {
-	var logLevel slog.LevelVar
 	logLevel.Set(level)
 
 	handler := internal.NewHandler(os.Stderr, &internal.ColorOptions{
@@ -73,37 +313,143 @@ func setupSlog(level slog.Level) {
 }
 
 func main() {
-	cfg := loadConfig()
-	setupSlog(strToLogLevel(cfg.logLevel))
 	ctx := context.Background()
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "listen":
+			cfg := loadListenConfig(os.Args[2:])
+			setupSlog(cfg.logLevel)
+			if err := runListen(ctx, cfg); err != nil {
+				exitWithError(ctx, "listen failed", err)
+			}
+			return
+		case "watch":
+			cfg := loadWatchConfig(os.Args[2:])
+			setupSlog(cfg.logLevel)
+			if err := runWatch(ctx, cfg); err != nil {
+				exitWithError(ctx, "watch failed", err)
+			}
+			return
+		}
+	}
+
+	cfg, err := loadConfig(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "http-watch: %s\n", err)
+		os.Exit(2)
+	}
+	setupSlog(cfg.logLevel)
+
 	if err := run(ctx, cfg); err != nil {
-		slog.ErrorContext(ctx, "run failed", slog.Any("error", err))
-		os.Exit(1)
+		exitWithError(ctx, "run failed", err)
+	}
+}
+
+// exitWithError logs err and exits. Invalid patterns are usage errors and
+// get a plain message instead.
+func exitWithError(ctx context.Context, msg string, err error) {
+	var patternErr *httpwatch.PatternError
+	if errors.As(err, &patternErr) {
+		reason := patternErr.Err.Error()
+		var syntaxErr *syntax.Error
+		if errors.As(patternErr.Err, &syntaxErr) {
+			reason = syntaxErr.Code.String()
+		}
+		fmt.Fprintf(os.Stderr, "http-watch: invalid pattern %q: %s\n", patternErr.Pattern, reason)
+		os.Exit(2)
+	}
+
+	slog.ErrorContext(ctx, msg, slog.Any("error", err))
+	os.Exit(1)
+}
+
+// runWatcher runs w until ctx is done, logging the errors it reports.
+func runWatcher(ctx context.Context, w *httpwatch.Watcher) {
+	go func() {
+		for err := range w.Errors() {
+			slog.ErrorContext(ctx, "watcher error", "error", err)
+		}
+	}()
+	if err := w.Run(ctx); err != nil {
+		slog.ErrorContext(ctx, "watcher stopped", "error", err)
 	}
 }
 
 func runServer(ctx context.Context, s *http.Server, cfg config) error {
+	// read once, the server and the redirect addresses share the sockets
+	inherited, err := inheritedListeners()
+	if err != nil {
+		return err
+	}
+	listeners, err := openListeners(cfg.addrs, inherited, cfg.portFallback)
+	var redirectListeners []net.Listener
+	if err == nil && cfg.hasTLS() && cfg.tlsRedirect != "" {
+		redirectListeners, err = openListeners([]string{cfg.tlsRedirect}, inherited, cfg.portFallback)
+		if err != nil {
+			closeListeners(listeners...)
+		}
+	}
+	// close the sockets no address uses
+	closeListeners(slices.Collect(maps.Values(inherited))...)
+	if err != nil {
+		return err
+	}
+
+	urls := make([]string, 0, len(listeners))
+	for _, l := range listeners {
+		urls = append(urls, listenerURL(l, cfg.hasTLS()))
+	}
+	if err := writeURLs(urls, cfg.urlFile, cfg.urlJSON); err != nil {
+		closeListeners(append(listeners, redirectListeners...)...)
+		return err
+	}
+	if cfg.urlFile != "" {
+		defer os.Remove(cfg.urlFile)
+	}
+	if cfg.loginToken != "" {
+		logLoginURL(ctx, urls, cfg.loginToken)
+	}
+
+	servers := []*http.Server{s}
 	srvErr := make(chan error, 1)
-	go func() {
-		slog.InfoContext(ctx, "listening for requests", "addr", s.Addr)
+	serve := func(s *http.Server, l net.Listener, useTLS bool) {
+		slog.InfoContext(ctx, "listening for requests", "url", listenerURL(l, useTLS))
 
-		listenAndServe := s.ListenAndServe
-		if cfg.hasTLS() {
-			listenAndServe = func() error {
-				return s.ListenAndServeTLS(cfg.tlsCert, cfg.tlsKey)
+		var err error
+		if useTLS {
+			err = s.ServeTLS(l, "", "")
+		} else {
+			err = s.Serve(l)
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			select {
+			case srvErr <- err:
+			default:
 			}
 		}
+	}
 
-		if err := listenAndServe(); !errors.Is(err, http.ErrServerClosed) {
-			srvErr <- err
+	if len(redirectListeners) > 0 {
+		redirect := &http.Server{
+			Handler:           newRedirectHandler(tcpPort(listeners)),
+			BaseContext:       s.BaseContext,
+			ReadHeaderTimeout: s.ReadHeaderTimeout,
+			IdleTimeout:       s.IdleTimeout,
 		}
-	}()
+		servers = append(servers, redirect)
+		for _, l := range redirectListeners {
+			go serve(redirect, l, false)
+		}
+	}
+	for _, l := range listeners {
+		go serve(s, l, cfg.hasTLS())
+	}
 
 	// Wait for interruption.
 	select {
 	case err := <-srvErr:
-		return fmt.Errorf("server.ListenAndServe(): %w", err)
+		return fmt.Errorf("server.Serve(): %w", err)
 	case <-ctx.Done():
 		// Wait for first CTRL+C.
 		// Stop receiving signal notifications as soon as possible.
@@ -114,46 +460,162 @@ func runServer(ctx context.Context, s *http.Server, cfg config) error {
 		context.Background(), 5*time.Second,
 	)
 	defer cancel()
-	return s.Shutdown(shutdownCtx)
+
+	var errs []error
+	for _, s := range servers {
+		errs = append(errs, s.Shutdown(shutdownCtx))
+	}
+	return errors.Join(errs...)
+}
+
+// logLoginURL logs the url signing in with the one-time login token.
+func logLoginURL(ctx context.Context, urls []string, token string) {
+	for _, u := range urls {
+		if strings.HasPrefix(u, "http") {
+			slog.InfoContext(ctx, "open to sign in, the login token works once", "url", u+"/?login="+token)
+			return
+		}
+	}
+	slog.InfoContext(ctx, "sign in with ?login= or as bearer token, it works once", "token", token)
+}
+
+// handleWithCORS registers handler for GET requests to pattern and answers
+// OPTIONS requests to it, both behind the cors middleware.
+func handleWithCORS(mux *http.ServeMux, pattern string, cors func(http.Handler) http.Handler, handler http.Handler) {
+	mux.Handle("GET "+pattern, cors(handler))
+	mux.Handle("OPTIONS "+pattern, cors(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	})))
 }
 
 func run(ctx context.Context, cfg config) error {
 	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
 	defer stop()
 
+	if cfg.tlsAuto && !cfg.hasTLS() {
+		certs, err := newAutoTLS()
+		if err != nil {
+			return err
+		}
+		if err := certs.ensure(tlsHosts()); err != nil {
+			return fmt.Errorf("tls.auto: %w", err)
+		}
+		cfg.tlsCert, cfg.tlsKey = certs.certFile, certs.keyFile
+		slog.InfoContext(ctx, "using a generated certificate, trust the CA once to avoid browser warnings", "ca", certs.caCertFile)
+	}
+
 	b := httpwatch.NewBroadcaster()
 	h := http.NewServeMux()
 
-	if cfg.FilePattern != "" {
-		fn, err := httpwatch.NewWatcherFn(ctx, cfg.WatcherConfig, b)
-		if err != nil {
-			return fmt.Errorf("createWatcherFn: %w", err)
-		}
-		go fn()
-		h.Handle("GET /_/events", httpwatch.NewWebsocketHandler(b))
+	watchers := &watcherGroup{b: b}
+	defer watchers.stop()
+	if err := watchers.update(ctx, cfg.watchers()); err != nil {
+		return err
 	}
 
+	// Only the file server and the events endpoint are shared with other
+	// origins, the debug and log endpoints expose or accept local details.
+	cors := httpwatch.NewCORSMiddleware(httpwatch.CORSConfig{
+		AllowedOrigins:   cfg.cors.origins,
+		AllowedMethods:   cfg.cors.methods,
+		AllowedHeaders:   cfg.cors.headers,
+		AllowCredentials: cfg.cors.credentials,
+		MaxAge:           cfg.cors.maxAge,
+	})
+
+	if cfg.debugWatch {
+		// registered even without watchers, a config reload may add some
+		h.Handle("GET /_/debug/watch", httpwatch.NewWatchStatsHandler())
+	}
+	handleWithCORS(h, "/_/events", cors, httpwatch.NewWebsocketHandler(b, httpwatch.WebsocketConfig{
+		Sync:           cfg.sync,
+		OriginPatterns: cfg.wsOriginPatterns(),
+		Token:          cfg.wsToken,
+		MaxConnsPerIP:  cfg.wsPerIP,
+	}))
+
+	headers := httpwatch.NewHeaderSet(cfg.responseHeaders())
 	if cfg.Dir != "" {
-		headers := http.Header{}
-		headers.Set("Cross-Origin-Opener-Policy", "same-origin")
-		headers.Set("Cross-Origin-Embedder-Policy", "require-corp")
-		headers.Set("Cache-Control", "max-age=0")
-		headers.Set("Access-Control-Allow-Origin", "*")
+		handler := httpwatch.NewFileServer(httpwatch.FileServerConfig{
+			Dir:           cfg.Dir,
+			Gzip:          cfg.gzip,
+			InjectClient:  cfg.client,
+			ClientToken:   cfg.wsToken,
+			ServeDotfiles: cfg.dotfiles,
+			Deny:          cfg.deny,
+			Allow:         cfg.allow,
+		})
+		handler = headers.Middleware(handler)
+		handleWithCORS(h, "/", cors, handler)
+	}
+
+	// the reloads must be done before the deferred watchers.stop runs
+	reloadCtx, stopReload := context.WithCancel(ctx)
+	reloadDone := make(chan struct{})
+	defer func() {
+		stopReload()
+		<-reloadDone
+	}()
+	current := cfg
+	go func() {
+		defer close(reloadDone)
+		reloadConfig(reloadCtx, cfg, func(newCfg config) {
+			current = applyConfig(reloadCtx, current, newCfg, headers, watchers)
+		})
+	}()
+
+	if cfg.client {
+		h.Handle("GET "+httpwatch.ClientScriptPath, httpwatch.NewClientScriptHandler())
+		h.Handle("POST "+httpwatch.ClientLogPath, httpwatch.NewClientLogHandler(httpwatch.ClientLogConfig{
+			OriginPatterns: cfg.wsOriginPatterns(),
+		}))
+	}
 
-		headersMW := httpwatch.HeaderMiddleware(headers)
-		handler := httpwatch.NewFileServer(cfg.Dir, cfg.gzip)
-		h.Handle("GET /", headersMW(handler))
+	var handler http.Handler = h
+	if cfg.htpasswd != "" || cfg.authToken {
+		var authCfg httpwatch.AuthConfig
+		if cfg.htpasswd != "" {
+			users, err := httpwatch.ReadHtpasswd(cfg.htpasswd)
+			if err != nil {
+				return fmt.Errorf("httpwatch.ReadHtpasswd: %w", err)
+			}
+			authCfg.Users = users
+		}
+		if cfg.authToken {
+			cfg.loginToken = httpwatch.NewLoginToken()
+			authCfg.LoginToken = cfg.loginToken
+		}
+		handler = httpwatch.NewAuth(authCfg).Middleware(h)
+	}
+	// in front of auth, so failed sign ins count too
+	if cfg.rateLimit.Rate > 0 {
+		handler = httpwatch.NewRateLimitMiddleware(cfg.rateLimit)(handler)
 	}
 
 	s := &http.Server{
-		Addr:    cfg.addr,
-		Handler: h,
+		Handler: handler,
 		BaseContext: func(_ net.Listener) context.Context {
 			return ctx
 		},
-		ReadTimeout:  time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  20 * time.Second,
+		ReadTimeout:       cfg.timeouts.read,
+		ReadHeaderTimeout: cfg.timeouts.readHeader,
+		WriteTimeout:      cfg.timeouts.write,
+		IdleTimeout:       cfg.timeouts.idle,
+	}
+	if cfg.h2c {
+		s.Protocols = new(http.Protocols)
+		s.Protocols.SetHTTP1(true)
+		s.Protocols.SetHTTP2(true)
+		s.Protocols.SetUnencryptedHTTP2(true)
+	}
+	if cfg.hasTLS() {
+		certs, err := newCertReloader(cfg.tlsCert, cfg.tlsKey)
+		if err != nil {
+			return err
+		}
+		go certs.watch(ctx, cfg.WatcherConfig)
+		s.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate}
 	}
 	return runServer(ctx, s, cfg)
 }
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	httpwatch "github.com/zhamlin/http-watch"
+)
+
+// reloadable are the flags a config reload applies while running, changing
+// any other flag requires a restart.
+var reloadable = map[string]bool{
+	"header":          true,
+	"log.level":       true,
+	"pattern":         true,
+	"rule":            true,
+	"watch":           true,
+	"recursive":       true,
+	"follow-symlinks": true,
+	"watcher":         true,
+	"poll.interval":   true,
+	"poll.hash":       true,
+}
+
+// settleDelay is how long a watched file has to stay unchanged before it
+// is reloaded.
+const settleDelay = 100 * time.Millisecond
+
+// watcherGroup runs the watchers of the current config, replacing all of
+// them when it changes. It is safe for concurrent use.
+type watcherGroup struct {
+	b *httpwatch.Broadcaster
+
+	mu      sync.Mutex
+	configs []httpwatch.WatcherConfig
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// update starts watchers for configs, stopping the running ones once all
+// new watchers were created. On error the running watchers are kept.
+func (g *watcherGroup) update(ctx context.Context, configs []httpwatch.WatcherConfig) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.cancel != nil && reflect.DeepEqual(configs, g.configs) {
+		return nil
+	}
+
+	watchers := make([]*httpwatch.Watcher, 0, len(configs))
+	for _, cfg := range configs {
+		w, err := httpwatch.NewWatcher(cfg, g.b)
+		if err != nil {
+			for _, w := range watchers {
+				_ = w.Close()
+			}
+			return fmt.Errorf("httpwatch.NewWatcher: %w", err)
+		}
+		watchers = append(watchers, w)
+	}
+
+	g.stopLocked()
+	runCtx, cancel := context.WithCancel(ctx)
+	g.configs = configs
+	g.cancel = cancel
+	for _, w := range watchers {
+		g.wg.Add(1)
+		go func() {
+			defer g.wg.Done()
+			runWatcher(runCtx, w)
+		}()
+	}
+	return nil
+}
+
+// stop stops the running watchers and waits for them to exit.
+func (g *watcherGroup) stop() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stopLocked()
+}
+
+func (g *watcherGroup) stopLocked() {
+	if g.cancel != nil {
+		g.cancel()
+		g.wg.Wait()
+		g.cancel = nil
+	}
+}
+
+// reloadConfig calls apply with the reloaded config whenever the config file
+// changes or the process receives SIGHUP.
+func reloadConfig(ctx context.Context, cfg config, apply func(config)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var changes httpwatch.Subscriber
+	if cfg.configPath != "" {
+		var err error
+		changes, err = watchFiles(ctx, cfg.WatcherConfig, cfg.configPath)
+		if err != nil {
+			slog.WarnContext(ctx, "not watching config file", "file", cfg.configPath, "error", err)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.InfoContext(ctx, "reloading config", "reason", "SIGHUP")
+		case <-changes:
+			// editors may truncate the file before writing it
+			settle(changes, settleDelay)
+			if _, err := os.Stat(cfg.configPath); err != nil {
+				continue
+			}
+			slog.InfoContext(ctx, "reloading config", "reason", "file changed", "file", cfg.configPath)
+		}
+
+		newCfg, err := loadConfig(os.Args[1:])
+		if err != nil {
+			slog.ErrorContext(ctx, "config reload failed, keeping the current config", "error", err)
+			continue
+		}
+		apply(newCfg)
+	}
+}
+
+// watchFiles returns a subscriber receiving the changes of files, using the
+// backend settings of cfg.
+func watchFiles(ctx context.Context, cfg httpwatch.WatcherConfig, files ...string) (httpwatch.Subscriber, error) {
+	dirs := map[string][]string{}
+	for _, file := range files {
+		dir := filepath.Dir(file)
+		dirs[dir] = append(dirs[dir], regexp.QuoteMeta(filepath.Base(file)))
+	}
+
+	b := httpwatch.NewBroadcaster()
+	watchers := make([]*httpwatch.Watcher, 0, len(dirs))
+	for dir, names := range dirs {
+		w, err := httpwatch.NewWatcher(httpwatch.WatcherConfig{
+			Dir:          dir,
+			FilePattern:  "^(" + strings.Join(names, "|") + ")$",
+			Backend:      cfg.Backend,
+			PollInterval: cfg.PollInterval,
+		}, b)
+		if err != nil {
+			for _, w := range watchers {
+				_ = w.Close()
+			}
+			return nil, fmt.Errorf("httpwatch.NewWatcher: %w", err)
+		}
+		watchers = append(watchers, w)
+	}
+
+	changes := b.AddSubscriber()
+	for _, w := range watchers {
+		go runWatcher(ctx, w)
+	}
+	return changes, nil
+}
+
+// settle drains changes until none arrived for delay.
+func settle(changes httpwatch.Subscriber, delay time.Duration) {
+	for {
+		select {
+		case <-changes:
+		case <-time.After(delay):
+			return
+		}
+	}
+}
+
+// applyConfig logs the differences between the running and the new config
+// and applies the reloadable ones, returning the config now in effect.
+func applyConfig(ctx context.Context, old, cfg config, headers *httpwatch.HeaderSet, watchers *watcherGroup) config {
+	next := old
+	next.values = map[string]string{}
+	for name, value := range old.values {
+		next.values[name] = value
+	}
+
+	names := make([]string, 0, len(cfg.values))
+	for name := range cfg.values {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		oldValue, value := old.values[name], cfg.values[name]
+		if oldValue == value {
+			continue
+		}
+		if !reloadable[name] {
+			slog.WarnContext(ctx, "config change requires a restart", "key", name, "old", oldValue, "new", value)
+			continue
+		}
+		slog.InfoContext(ctx, "config changed", "key", name, "old", oldValue, "new", value)
+		next.values[name] = value
+	}
+
+	if next.values["log.level"] != old.values["log.level"] {
+		logLevel.Set(cfg.logLevel)
+		next.logLevel = cfg.logLevel
+	}
+
+	next.headers = cfg.headers
+	headers.Store(next.responseHeaders())
+
+	// the served directory is not reloadable, it stays the watched one too
+	next.WatcherConfig = cfg.WatcherConfig
+	next.Dir = old.Dir
+	next.watches = cfg.watches
+	if err := watchers.update(ctx, next.watchers()); err != nil {
+		slog.ErrorContext(ctx, "keeping the current watchers", "error", err)
+		next.WatcherConfig = old.WatcherConfig
+		next.watches = old.watches
+		for name := range reloadable {
+			if name != "header" && name != "log.level" {
+				next.values[name] = old.values[name]
+			}
+		}
+	}
+	return next
+}
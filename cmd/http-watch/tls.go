@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	httpwatch "github.com/zhamlin/http-watch"
+)
+
+const (
+	caValidity = 10 * 365 * 24 * time.Hour
+	// leafValidity stays below the 398 days browsers accept for leaf certs.
+	leafValidity = 397 * 24 * time.Hour
+	// leafRenewal regenerates leaf certs that expire sooner than this.
+	leafRenewal = 7 * 24 * time.Hour
+)
+
+// certReloader serves the key pair read from certFile and keyFile, swapping
+// in a new one when the files change.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("tls.LoadX509KeyPair: %w", err)
+	}
+	r.cert.Store(&cert)
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// watch reloads the key pair whenever one of its files changes, keeping the
+// current one when the new files fail to load, e.g. while only one of them
+// was replaced.
+func (r *certReloader) watch(ctx context.Context, cfg httpwatch.WatcherConfig) {
+	changes, err := watchFiles(ctx, cfg, r.certFile, r.keyFile)
+	if err != nil {
+		slog.WarnContext(ctx, "not watching tls certificate", "error", err)
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changes:
+		}
+
+		settle(changes, settleDelay)
+		if err := r.load(); err != nil {
+			slog.ErrorContext(ctx, "tls certificate reload failed, keeping the current one", "error", err)
+			continue
+		}
+		slog.InfoContext(ctx, "reloaded tls certificate", "cert", r.certFile)
+	}
+}
+
+// autoTLS holds the paths of the generated local CA and leaf certificate.
+type autoTLS struct {
+	dir        string
+	caCertFile string
+	caKeyFile  string
+	certFile   string
+	keyFile    string
+}
+
+// newAutoTLS returns the certificate paths below the user config dir.
+func newAutoTLS() (autoTLS, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return autoTLS{}, fmt.Errorf("os.UserConfigDir: %w", err)
+	}
+	dir = filepath.Join(dir, "http-watch", "tls")
+	return autoTLS{
+		dir:        dir,
+		caCertFile: filepath.Join(dir, "ca.pem"),
+		caKeyFile:  filepath.Join(dir, "ca-key.pem"),
+		certFile:   filepath.Join(dir, "cert.pem"),
+		keyFile:    filepath.Join(dir, "key.pem"),
+	}, nil
+}
+
+// ensure creates the CA when missing and a leaf cert signed by it whenever
+// the cached one is missing, about to expire or doesn't cover all hosts.
+func (a autoTLS) ensure(hosts []string) error {
+	if err := os.MkdirAll(a.dir, 0o700); err != nil {
+		return fmt.Errorf("os.MkdirAll: %w", err)
+	}
+
+	ca, caKey, err := a.loadCA()
+	if errors.Is(err, fs.ErrNotExist) {
+		ca, caKey, err = a.createCA()
+	}
+	if err != nil {
+		return err
+	}
+
+	if leaf, err := readCert(a.certFile); err == nil && leafValid(leaf, ca, hosts) {
+		return nil
+	}
+	return a.createLeaf(ca, caKey, hosts)
+}
+
+func (a autoTLS) loadCA() (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	pair, err := tls.LoadX509KeyPair(a.caCertFile, a.caKeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s: unsupported key type %T", a.caKeyFile, pair.PrivateKey)
+	}
+	ca, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("x509.ParseCertificate: %w", err)
+	}
+	return ca, key, nil
+}
+
+func (a autoTLS) createCA() (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ecdsa.GenerateKey: %w", err)
+	}
+
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber: serialNumber(),
+		Subject: pkix.Name{
+			Organization: []string{"http-watch development CA"},
+			CommonName:   "http-watch CA " + hostname,
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("x509.CreateCertificate: %w", err)
+	}
+	if err := writeKey(a.caKeyFile, key); err != nil {
+		return nil, nil, err
+	}
+	if err := writeCert(a.caCertFile, der); err != nil {
+		return nil, nil, err
+	}
+
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("x509.ParseCertificate: %w", err)
+	}
+	return ca, key, nil
+}
+
+func (a autoTLS) createLeaf(ca *x509.Certificate, caKey *ecdsa.PrivateKey, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("ecdsa.GenerateKey: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serialNumber(),
+		Subject: pkix.Name{
+			Organization: []string{"http-watch development certificate"},
+		},
+		NotBefore:   time.Now().Add(-time.Hour),
+		NotAfter:    time.Now().Add(leafValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return fmt.Errorf("x509.CreateCertificate: %w", err)
+	}
+	if err := writeKey(a.keyFile, key); err != nil {
+		return err
+	}
+	return writeCert(a.certFile, der)
+}
+
+// leafValid reports if leaf was signed by ca, is not about to expire and
+// covers all hosts.
+func leafValid(leaf, ca *x509.Certificate, hosts []string) bool {
+	if leaf.CheckSignatureFrom(ca) != nil || time.Until(leaf.NotAfter) < leafRenewal {
+		return false
+	}
+	for _, host := range hosts {
+		if leaf.VerifyHostname(host) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// tlsHosts returns the names and addresses the leaf cert is valid for:
+// localhost, the machine hostname and the addresses of all interfaces.
+func tlsHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		hosts = append(hosts, hostname)
+	}
+
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() {
+			continue
+		}
+		if ip := ipNet.IP.String(); !slices.Contains(hosts, ip) {
+			hosts = append(hosts, ip)
+		}
+	}
+	return hosts
+}
+
+func serialNumber() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		panic("rand.Int: " + err.Error())
+	}
+	return serial
+}
+
+func readCert(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s: no certificate found", path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func writeCert(path string, der []byte) error {
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("os.WriteFile: %w", err)
+	}
+	return nil
+}
+
+func writeKey(path string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("x509.MarshalPKCS8PrivateKey: %w", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("os.WriteFile: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	httpwatch "github.com/zhamlin/http-watch"
+)
+
+type watchConfig struct {
+	httpwatch.WatcherConfig
+	logLevel slog.Level
+	debounce time.Duration
+	command  []string
+	watches  stringsFlag
+}
+
+func loadWatchConfig(args []string) watchConfig {
+	cfg := watchConfig{}
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s watch [flags] -- command [args]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&cfg.Dir, "dir", ".", "directory to watch")
+	fs.StringVar(&cfg.FilePattern, "pattern", "", "file matching pattern")
+	fs.TextVar(&cfg.logLevel, "log.level", slog.LevelInfo, "slog log level to use: debug, info, warn or error")
+	fs.BoolVar(&cfg.Recursive, "recursive", true, "watch all files recursively")
+	fs.BoolVar(&cfg.FollowSymlinks, "follow-symlinks", false, "watch symlinked directories when recursive")
+	fs.Var(&cfg.watches, "watch", watchFlagUsage)
+	backendFlags(fs, &cfg.WatcherConfig)
+	fs.DurationVar(&cfg.debounce, "debounce", 100*time.Millisecond, "time to wait for more changes before running the command")
+
+	_ = fs.Parse(args)
+	cfg.command = fs.Args()
+	if len(cfg.command) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	return cfg
+}
+
+func runWatch(ctx context.Context, cfg watchConfig) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	b := httpwatch.NewBroadcaster()
+	subscriber := b.AddSubscriber()
+	defer b.Remove(subscriber)
+
+	watchers := []httpwatch.WatcherConfig{cfg.WatcherConfig}
+	for _, spec := range cfg.watches {
+		watchers = append(watchers, parseWatchSpec(spec, cfg.WatcherConfig))
+	}
+	for _, watcherCfg := range watchers {
+		w, err := httpwatch.NewWatcher(watcherCfg, b)
+		if err != nil {
+			return fmt.Errorf("httpwatch.NewWatcher: %w", err)
+		}
+		go runWatcher(ctx, w)
+	}
+
+	pending := map[string]bool{}
+	var debounce <-chan time.Time
+	// running is closed once the current command exits
+	var running chan struct{}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if running != nil {
+				<-running
+			}
+			return nil
+		case msg := <-subscriber:
+			change, ok := msg.Data.(httpwatch.FileChange)
+			if !ok {
+				continue
+			}
+			pending[filepath.Join(change.Root, change.Path)] = true
+			debounce = time.After(cfg.debounce)
+		case <-running:
+			running = nil
+			if len(pending) > 0 && debounce == nil {
+				debounce = time.After(0)
+			}
+		case <-debounce:
+			debounce = nil
+			if running != nil {
+				// picked up again once the command exits
+				continue
+			}
+
+			files := make([]string, 0, len(pending))
+			for file := range pending {
+				files = append(files, file)
+			}
+			slices.Sort(files)
+			clear(pending)
+
+			running = make(chan struct{})
+			go func(done chan struct{}) {
+				defer close(done)
+				runBatch(ctx, cfg.command, files)
+			}(running)
+		}
+	}
+}
+
+// runBatch runs the command with the changed files listed in HTTPWATCH_FILES,
+// separated by the os path list separator.
+func runBatch(ctx context.Context, command []string, files []string) {
+	slog.DebugContext(ctx, "running command", "cmd", command, "files", files)
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"HTTPWATCH_FILES="+strings.Join(files, string(os.PathListSeparator)),
+	)
+	if err := cmd.Run(); err != nil {
+		slog.ErrorContext(ctx, "command failed", "cmd", command, "err", err)
+	}
+}
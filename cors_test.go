@@ -0,0 +1,124 @@
+package httpwatch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name       string
+		cfg        CORSConfig
+		method     string
+		header     http.Header
+		wantStatus int
+		want       map[string]string
+	}{
+		{
+			name:       "preflight any origin",
+			cfg:        CORSConfig{AllowedOrigins: []string{"*"}, MaxAge: 10 * time.Minute},
+			method:     http.MethodOptions,
+			header:     http.Header{"Origin": {"http://a.test"}, "Access-Control-Request-Method": {"GET"}},
+			wantStatus: http.StatusNoContent,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":  "*",
+				"Access-Control-Allow-Methods": "GET, HEAD",
+				"Access-Control-Max-Age":       "600",
+			},
+		},
+		{
+			name:       "preflight matching pattern",
+			cfg:        CORSConfig{AllowedOrigins: []string{"http://localhost:*"}, AllowedMethods: []string{"GET", "POST"}, AllowedHeaders: []string{"Content-Type"}},
+			method:     http.MethodOptions,
+			header:     http.Header{"Origin": {"http://localhost:3000"}, "Access-Control-Request-Method": {"POST"}},
+			wantStatus: http.StatusNoContent,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":  "http://localhost:3000",
+				"Access-Control-Allow-Methods": "GET, POST",
+				"Access-Control-Allow-Headers": "Content-Type",
+			},
+		},
+		{
+			name:       "preflight reflects requested headers",
+			cfg:        CORSConfig{AllowedOrigins: []string{"*"}, AllowedHeaders: []string{"*"}},
+			method:     http.MethodOptions,
+			header:     http.Header{"Origin": {"http://a.test"}, "Access-Control-Request-Method": {"GET"}, "Access-Control-Request-Headers": {"X-One, X-Two"}},
+			wantStatus: http.StatusNoContent,
+			want:       map[string]string{"Access-Control-Allow-Headers": "X-One, X-Two"},
+		},
+		{
+			name:       "preflight with credentials names the origin",
+			cfg:        CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+			method:     http.MethodOptions,
+			header:     http.Header{"Origin": {"http://a.test"}, "Access-Control-Request-Method": {"GET"}},
+			wantStatus: http.StatusNoContent,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":      "http://a.test",
+				"Access-Control-Allow-Credentials": "true",
+			},
+		},
+		{
+			name:       "preflight from disallowed origin",
+			cfg:        CORSConfig{AllowedOrigins: []string{"http://localhost:*"}},
+			method:     http.MethodOptions,
+			header:     http.Header{"Origin": {"http://evil.test"}, "Access-Control-Request-Method": {"GET"}},
+			wantStatus: http.StatusForbidden,
+			want:       map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:       "request from allowed origin",
+			cfg:        CORSConfig{AllowedOrigins: []string{"http://localhost:*"}},
+			method:     http.MethodGet,
+			header:     http.Header{"Origin": {"http://LOCALHOST:8080"}},
+			wantStatus: http.StatusOK,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":  "http://LOCALHOST:8080",
+				"Access-Control-Allow-Methods": "",
+			},
+		},
+		{
+			name:       "request from disallowed origin",
+			cfg:        CORSConfig{AllowedOrigins: []string{"http://localhost:*"}},
+			method:     http.MethodGet,
+			header:     http.Header{"Origin": {"http://evil.test"}},
+			wantStatus: http.StatusOK,
+			want:       map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:       "options without preflight headers",
+			cfg:        CORSConfig{AllowedOrigins: []string{"*"}},
+			method:     http.MethodOptions,
+			wantStatus: http.StatusOK,
+			want:       map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/", nil)
+			for key, values := range tt.header {
+				r.Header[key] = values
+			}
+			w := httptest.NewRecorder()
+			NewCORSMiddleware(tt.cfg)(next).ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+			for key, want := range tt.want {
+				if got := w.Header().Get(key); got != want {
+					t.Errorf("%s: got %q, want %q", key, got, want)
+				}
+			}
+			if got := w.Header().Get("Vary"); got != "Origin" {
+				t.Errorf("Vary: got %q, want Origin", got)
+			}
+		})
+	}
+}
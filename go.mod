@@ -1,6 +1,6 @@
 module github.com/zhamlin/http-watch
 
-go 1.22
+go 1.24
 
 require (
 	github.com/coder/websocket v1.8.12
@@ -2,22 +2,23 @@ package httpwatch
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/coder/websocket"
 )
 
-type websocketMessage struct {
-	Type string `json:"type"`
-	Data any    `json:"data"`
-}
-
-func writeMessage(ctx context.Context, c *websocket.Conn, msg websocketMessage) error {
+func writeMessage(ctx context.Context, c *websocket.Conn, msg Message) error {
 	data, err := json.Marshal(&msg)
 	if err != nil {
 		return fmt.Errorf("json.Marshal: %w", err)
@@ -31,11 +32,153 @@ func writeMessage(ctx context.Context, c *websocket.Conn, msg websocketMessage)
 	return nil
 }
 
-func NewWebsocketHandler(b *Broadcaster) http.HandlerFunc {
+// readSyncMessages relays sync events sent by the client until the connection
+// is closed. The returned context is done once reading stops.
+func readSyncMessages(ctx context.Context, c *websocket.Conn, b *Broadcaster, s Subscriber) context.Context {
+	ctx, cancel := context.WithCancel(ctx)
+	b.JoinSync(s)
+	go func() {
+		defer cancel()
+		for {
+			_, data, err := c.Read(ctx)
+			if err != nil {
+				return
+			}
+
+			var msg struct {
+				Type string          `json:"type"`
+				Data json.RawMessage `json:"data"`
+			}
+			if err := json.Unmarshal(data, &msg); err != nil {
+				slog.DebugContext(ctx, "websocket invalid message", "err", err)
+				continue
+			}
+			if !strings.HasPrefix(msg.Type, "sync.") {
+				continue
+			}
+			b.Relay(s, Message{Type: msg.Type, Data: msg.Data})
+		}
+	}()
+	return ctx
+}
+
+// TokenSubprotocolPrefix is prepended to the token when a client sends it as
+// websocket subprotocol instead of the token query parameter.
+const TokenSubprotocolPrefix = "http-watch.token."
+
+type WebsocketConfig struct {
+	// Sync relays navigation, scrolling, input and clicks between clients.
+	Sync bool
+	// OriginPatterns are the hosts, as path.Match patterns, of pages allowed
+	// to connect besides the server's own host. Patterns containing "://"
+	// are matched against the whole origin.
+	OriginPatterns []string
+	// Token, when set, is required from clients as the token query parameter
+	// or as a subprotocol prefixed with TokenSubprotocolPrefix.
+	Token string
+	// MaxConnsPerIP limits the open connections per client IP, further ones
+	// are rejected with 429. Zero means no limit.
+	MaxConnsPerIP int
+}
+
+// originAllowed reports if a page from origin may connect. Clients that
+// aren't browsers don't send an origin.
+func originAllowed(r *http.Request, origin string, patterns []string) bool {
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		target := u.Host
+		if strings.Contains(pattern, "://") {
+			target = origin
+		}
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(target)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkToken reports if the request carries token, returning the subprotocol
+// it was sent with, if any.
+func checkToken(r *http.Request, token string) (string, bool) {
+	if tokenEqual(r.URL.Query().Get("token"), token) {
+		return "", true
+	}
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			protocol = strings.TrimSpace(protocol)
+			value, ok := strings.CutPrefix(protocol, TokenSubprotocolPrefix)
+			if ok && tokenEqual(value, token) {
+				return protocol, true
+			}
+		}
+	}
+	return "", false
+}
+
+func tokenEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func NewWebsocketHandler(b *Broadcaster, cfg WebsocketConfig) http.HandlerFunc {
+	conns := newConnLimiter(cfg.MaxConnsPerIP)
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
+		// since is the last message ID a reconnecting client has seen
+		since := r.URL.Query().Get("since")
+		sinceID, err := strconv.ParseUint(since, 10, 64)
+		if since != "" && err != nil {
+			http.Error(w, "invalid since parameter", http.StatusBadRequest)
+			return
+		}
+
+		// the connection outlives the server's read and write timeouts
+		rc := http.NewResponseController(w)
+		_ = rc.SetReadDeadline(time.Time{})
+		_ = rc.SetWriteDeadline(time.Time{})
+
+		origin := r.Header.Get("Origin")
+		if !originAllowed(r, origin, cfg.OriginPatterns) {
+			slog.WarnContext(ctx, "rejected websocket connection", "reason", "origin not allowed", "origin", origin, "remote_addr", r.RemoteAddr)
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+
+		var subprotocols []string
+		if cfg.Token != "" {
+			protocol, ok := checkToken(r, cfg.Token)
+			if !ok {
+				slog.WarnContext(ctx, "rejected websocket connection", "reason", "invalid token", "origin", origin, "remote_addr", r.RemoteAddr)
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+			if protocol != "" {
+				subprotocols = []string{protocol}
+			}
+		}
+
+		ip := clientIP(r)
+		if !conns.acquire(ip) {
+			slog.WarnContext(ctx, "rejected websocket connection", "reason", "too many connections", "remote_ip", ip, "max", cfg.MaxConnsPerIP)
+			http.Error(w, "too many connections", http.StatusTooManyRequests)
+			return
+		}
+		defer conns.release(ip)
+
 		c, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+			Subprotocols: subprotocols,
+			// the origin was checked above
 			InsecureSkipVerify: true,
 		})
 		if err != nil {
@@ -43,7 +186,6 @@ func NewWebsocketHandler(b *Broadcaster) http.HandlerFunc {
 			return
 		}
 
-		wsCtx := c.CloseRead(context.Background())
 		closeFn := func(code websocket.StatusCode) {
 			err := c.Close(code, "")
 			slog.DebugContext(ctx,
@@ -55,9 +197,36 @@ func NewWebsocketHandler(b *Broadcaster) http.HandlerFunc {
 		defer closeFn(websocket.StatusNormalClosure)
 
 		slog.InfoContext(ctx, "websocket connected")
-		subscriber := b.AddSubscriber()
+		var subscriber Subscriber
+		var missed []Message
+		if since != "" {
+			subscriber, missed = b.AddSubscriberSince(sinceID)
+		} else {
+			subscriber = b.AddSubscriber()
+		}
 		defer b.Remove(subscriber)
 
+		// Only browsers sync, other clients could otherwise send events,
+		// such as navigation, to pages without being one
+		var wsCtx context.Context
+		if cfg.Sync && origin != "" {
+			wsCtx = readSyncMessages(context.Background(), c, b, subscriber)
+			err := writeMessage(ctx, c, Message{Type: "sync.enabled"})
+			if err != nil {
+				slog.DebugContext(ctx, "writeMessage", "err", err)
+				return
+			}
+		} else {
+			wsCtx = c.CloseRead(context.Background())
+		}
+
+		for _, msg := range missed {
+			if err := writeMessage(ctx, c, msg); err != nil {
+				slog.DebugContext(ctx, "writeMessage", "err", err, "msg", msg)
+				return
+			}
+		}
+
 		pingTicker := time.NewTicker(30 * time.Second)
 		defer pingTicker.Stop()
 
@@ -75,13 +244,8 @@ func NewWebsocketHandler(b *Broadcaster) http.HandlerFunc {
 					slog.DebugContext(ctx, "websocket ping error", "err", err)
 					return
 				}
-			case filename := <-subscriber:
-				slog.DebugContext(ctx, "websocket got file change", "file", filename)
-				msg := websocketMessage{
-					Type: "file.change",
-					Data: filename,
-				}
-
+			case msg := <-subscriber:
+				slog.DebugContext(ctx, "websocket got message", "type", msg.Type)
 				if err := writeMessage(ctx, c, msg); err != nil {
 					slog.DebugContext(ctx, "writeMessage", "err", err, "msg", msg)
 					return
@@ -91,16 +255,52 @@ func NewWebsocketHandler(b *Broadcaster) http.HandlerFunc {
 	}
 }
 
-func NewFileServer(dir string, useGzip bool) http.Handler {
-	f := os.DirFS(dir)
+type FileServerConfig struct {
+	Dir  string
+	Gzip bool
+	// InjectClient adds the browser client script to served html pages.
+	InjectClient bool
+	// ClientToken is passed to the injected client for connecting to a
+	// websocket handler requiring a token.
+	ClientToken string
+	// ServeDotfiles serves files and directories whose name starts with a
+	// dot, which are hidden by default.
+	ServeDotfiles bool
+	// Deny hides files matching these MatchGlob patterns. Hidden files are
+	// answered with 404 and left out of directory listings.
+	Deny []string
+	// Allow serves files matching these patterns even when hidden by Deny
+	// or as dotfile.
+	Allow []string
+}
+
+func NewFileServer(cfg FileServerConfig) http.Handler {
+	f := protectedFS{
+		fsys:     os.DirFS(cfg.Dir),
+		dotfiles: cfg.ServeDotfiles,
+		deny:     cfg.Deny,
+		allow:    cfg.Allow,
+	}
 	handler := http.FileServerFS(f)
 
-	if useGzip {
+	if cfg.InjectClient {
+		handler = newInjectHandler(handler, cfg.ClientToken)
+	}
+	if cfg.Gzip {
 		return newGzipHandler(handler)
 	}
 	return handler
 }
 
+// NewWatchStatsHandler serves the number of watched directories and tracked
+// events of every running watcher as json.
+func NewWatchStatsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(watchStats)
+	}
+}
+
 // HeaderMiddleware returns a middleware that sets one or more values per header key
 func HeaderMiddleware(headers http.Header) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -114,3 +314,32 @@ func HeaderMiddleware(headers http.Header) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// HeaderSet holds response headers that can be replaced while serving.
+type HeaderSet struct {
+	headers atomic.Pointer[http.Header]
+}
+
+func NewHeaderSet(headers http.Header) *HeaderSet {
+	s := &HeaderSet{}
+	s.Store(headers)
+	return s
+}
+
+// Store replaces the headers set on responses.
+func (s *HeaderSet) Store(headers http.Header) {
+	headers = headers.Clone()
+	s.headers.Store(&headers)
+}
+
+// Middleware sets the current headers on every response, like HeaderMiddleware.
+func (s *HeaderSet) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for key, values := range *s.headers.Load() {
+			for _, value := range values {
+				w.Header().Add(key, value)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,84 @@
+package httpwatch
+
+import (
+	"container/list"
+	"path/filepath"
+	"strings"
+)
+
+// maxHashEntries bounds how many file hashes are remembered.
+const maxHashEntries = 4096
+
+type hashEntry struct {
+	path string
+	hash string
+}
+
+// hashCache remembers the content hash of recently changed files, evicting
+// the least recently used entries once it holds max files.
+type hashCache struct {
+	max   int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+func newHashCache(max int) *hashCache {
+	return &hashCache{
+		max:   max,
+		ll:    list.New(),
+		items: map[string]*list.Element{},
+	}
+}
+
+// update stores the hash for path and reports if it differs from the
+// previous one. Files seen for the first time always count as changed.
+func (c *hashCache) update(path, hash string) bool {
+	if el, ok := c.items[path]; ok {
+		c.ll.MoveToFront(el)
+		entry := el.Value.(*hashEntry)
+		changed := entry.hash != hash
+		entry.hash = hash
+		return changed
+	}
+
+	c.items[path] = c.ll.PushFront(&hashEntry{path: path, hash: hash})
+	if c.ll.Len() > c.max {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*hashEntry).path)
+	}
+	return true
+}
+
+// seed remembers that path exists without knowing its hash, unless the
+// cache is full. Seeded files are reported as changed on their first update.
+func (c *hashCache) seed(path string) {
+	if _, ok := c.items[path]; ok || c.ll.Len() >= c.max {
+		return
+	}
+	c.items[path] = c.ll.PushBack(&hashEntry{path: path})
+}
+
+func (c *hashCache) has(path string) bool {
+	_, ok := c.items[path]
+	return ok
+}
+
+// below returns the remembered files below dir.
+func (c *hashCache) below(dir string) []string {
+	prefix := dir + string(filepath.Separator)
+	var paths []string
+	for path := range c.items {
+		if strings.HasPrefix(path, prefix) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+func (c *hashCache) remove(path string) {
+	if el, ok := c.items[path]; ok {
+		c.ll.Remove(el)
+		delete(c.items, path)
+	}
+}
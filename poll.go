@@ -0,0 +1,245 @@
+package httpwatch
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+	isDir   bool
+	hash    string
+}
+
+// PollWatcher is a Backend that periodically scans the watched directories,
+// for filesystems where fsnotify misses changes (NFS, SSHFS, some FUSE and
+// VM bind mounts).
+type PollWatcher struct {
+	interval time.Duration
+	hash     bool
+
+	events chan fsnotify.Event
+	errors chan error
+	done   chan struct{}
+	once   sync.Once
+
+	mu   sync.Mutex
+	dirs map[string]map[string]fileState
+}
+
+// NewPollWatcher creates a PollWatcher scanning every interval. Files are
+// compared by mtime and size, and by content hash when hash is set.
+func NewPollWatcher(interval time.Duration, hash bool) *PollWatcher {
+	w := &PollWatcher{
+		interval: interval,
+		hash:     hash,
+		events:   make(chan fsnotify.Event),
+		errors:   make(chan error),
+		done:     make(chan struct{}),
+		dirs:     map[string]map[string]fileState{},
+	}
+	go w.run()
+	return w
+}
+
+func (w *PollWatcher) Events() <-chan fsnotify.Event {
+	return w.events
+}
+
+func (w *PollWatcher) Errors() <-chan error {
+	return w.errors
+}
+
+// Add starts watching the entries of the directory at path.
+func (w *PollWatcher) Add(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("poll: not a directory: %q", path)
+	}
+
+	entries, err := w.scan(path)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.dirs[filepath.Clean(path)] = entries
+	return nil
+}
+
+// Remove stops watching the directory at path.
+func (w *PollWatcher) Remove(path string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	path = filepath.Clean(path)
+	if _, ok := w.dirs[path]; !ok {
+		return fmt.Errorf("poll: can't remove non-existent watch: %q", path)
+	}
+	delete(w.dirs, path)
+	return nil
+}
+
+func (w *PollWatcher) Close() error {
+	w.once.Do(func() {
+		close(w.done)
+	})
+	return nil
+}
+
+func (w *PollWatcher) scan(dir string) (map[string]fileState, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]fileState, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		state := fileState{
+			modTime: info.ModTime(),
+			size:    info.Size(),
+			isDir:   info.IsDir(),
+		}
+		if w.hash && info.Mode().IsRegular() {
+			state.hash, _ = hashFile(filepath.Join(dir, entry.Name()))
+		}
+		states[entry.Name()] = state
+	}
+	return states, nil
+}
+
+// poll scans all watched directories and returns the changes since the
+// previous scan.
+func (w *PollWatcher) poll() ([]fsnotify.Event, []error) {
+	w.mu.Lock()
+	dirs := make(map[string]map[string]fileState, len(w.dirs))
+	for dir, states := range w.dirs {
+		dirs[dir] = states
+	}
+	w.mu.Unlock()
+
+	// deepest first, so the changes below a removed directory are reported
+	// before the directory, as with inotify
+	order := slices.SortedFunc(maps.Keys(dirs), func(a, b string) int {
+		return cmp.Compare(len(b), len(a))
+	})
+
+	var events []fsnotify.Event
+	var errs []error
+	for _, dir := range order {
+		prev := dirs[dir]
+		cur, err := w.scan(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			w.mu.Lock()
+			delete(w.dirs, dir)
+			w.mu.Unlock()
+			// like inotify, report the files before the directory itself
+			for name, state := range prev {
+				if !state.isDir {
+					events = append(events, fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Remove})
+				}
+			}
+			events = append(events, fsnotify.Event{Name: dir, Op: fsnotify.Remove})
+			continue
+		} else if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		for name, state := range cur {
+			path := filepath.Join(dir, name)
+			old, ok := prev[name]
+			switch {
+			case !ok:
+				events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Create})
+			case state.isDir:
+			case !state.modTime.Equal(old.modTime), state.size != old.size, state.hash != old.hash:
+				events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Write})
+			}
+		}
+		for name := range prev {
+			if _, ok := cur[name]; !ok {
+				path := filepath.Join(dir, name)
+				events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Remove})
+			}
+		}
+
+		w.mu.Lock()
+		if _, ok := w.dirs[dir]; ok {
+			w.dirs[dir] = cur
+		}
+		w.mu.Unlock()
+	}
+	return events, errs
+}
+
+func (w *PollWatcher) run() {
+	defer close(w.events)
+	defer close(w.errors)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+
+		events, errs := w.poll()
+		for _, event := range events {
+			select {
+			case w.events <- event:
+			case <-w.done:
+				return
+			}
+		}
+		for _, err := range errs {
+			select {
+			case w.errors <- err:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// hashFile returns the hex encoded sha256 of the file contents.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
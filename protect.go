@@ -0,0 +1,139 @@
+package httpwatch
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// protectedFS hides dotfiles and files matching deny patterns, unless they
+// match an allow pattern. Hidden files don't exist as far as the file server
+// is concerned, so requesting them results in 404 and directory listings
+// leave them out.
+type protectedFS struct {
+	fsys     fs.FS
+	dotfiles bool
+	deny     []string
+	allow    []string
+}
+
+// hidden reports if the file at name, or one of its parent directories, is
+// hidden.
+func (p protectedFS) hidden(name string) bool {
+	if name == "." {
+		return false
+	}
+
+	parts := strings.Split(name, "/")
+	for i := range parts {
+		if matchAnyGlob(p.allow, strings.Join(parts[:i+1], "/")) {
+			return false
+		}
+	}
+	for i, part := range parts {
+		if !p.dotfiles && strings.HasPrefix(part, ".") {
+			return true
+		}
+		if matchAnyGlob(p.deny, strings.Join(parts[:i+1], "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p protectedFS) Open(name string) (fs.File, error) {
+	if p.hidden(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	f, err := p.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil || !info.IsDir() {
+		return f, nil
+	}
+	if dir, ok := f.(fs.ReadDirFile); ok {
+		return protectedDir{ReadDirFile: dir, fsys: p, name: name}, nil
+	}
+	return f, nil
+}
+
+// protectedDir leaves hidden entries out of directory listings.
+type protectedDir struct {
+	fs.ReadDirFile
+	fsys protectedFS
+	name string
+}
+
+func (d protectedDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	for {
+		entries, err := d.ReadDirFile.ReadDir(n)
+		visible := entries[:0]
+		for _, entry := range entries {
+			if !d.fsys.hidden(path.Join(d.name, entry.Name())) {
+				visible = append(visible, entry)
+			}
+		}
+		// a batch of only hidden entries must not look like the end
+		if len(visible) > 0 || err != nil || n <= 0 {
+			return visible, err
+		}
+	}
+}
+
+// matchAnyGlob reports if name matches one of patterns, ignoring case: on
+// case-insensitive filesystems, such as the macOS and Windows defaults,
+// /Secrets/A.KEY opens secrets/a.key.
+func matchAnyGlob(patterns []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		if MatchGlob(strings.ToLower(pattern), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchGlob reports if name, a slash separated path, matches pattern.
+// Patterns without a slash match the base name at any depth and "**" matches
+// any number of directories, e.g. "*.key" or "secrets/**". Malformed patterns
+// never match, see ValidGlob.
+func MatchGlob(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+	return matchGlobParts(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchGlobParts(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchGlobParts(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// ValidGlob returns an error when pattern is malformed.
+func ValidGlob(pattern string) error {
+	for _, part := range strings.Split(pattern, "/") {
+		if _, err := path.Match(part, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
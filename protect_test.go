@@ -0,0 +1,111 @@
+package httpwatch
+
+import (
+	"errors"
+	"io/fs"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"*.key", "server.key", true},
+		{"*.key", "certs/server.key", true},
+		{"*.key", "server.key.pub", false},
+		{"secrets/**", "secrets/a", true},
+		{"secrets/**", "secrets/a/b.txt", true},
+		{"secrets/**", "other/secrets/a", false},
+		{"**/node_modules", "a/b/node_modules", true},
+		{"a/**/c", "a/c", true},
+		{"a/**/c", "a/b/b/c", true},
+		{"a/*/c", "a/b/b/c", false},
+		{"build", "build", true},
+		{"build", "src/build", true},
+		{"[", "[", false},
+	}
+
+	for _, tt := range tests {
+		if got := MatchGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("MatchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidGlob(t *testing.T) {
+	for _, pattern := range []string{"*.key", "secrets/**", "a/[bc]/d"} {
+		if err := ValidGlob(pattern); err != nil {
+			t.Errorf("ValidGlob(%q) = %v", pattern, err)
+		}
+	}
+	for _, pattern := range []string{"[", "a/[b/c"} {
+		if err := ValidGlob(pattern); err == nil {
+			t.Errorf("ValidGlob(%q): expected an error", pattern)
+		}
+	}
+}
+
+func TestProtectedFSHidden(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys protectedFS
+		path string
+		want bool
+	}{
+		{"root", protectedFS{}, ".", false},
+		{"file", protectedFS{}, "index.html", false},
+		{"dotfile", protectedFS{}, ".env", true},
+		{"in dot directory", protectedFS{}, ".git/config", true},
+		{"dotfiles served", protectedFS{dotfiles: true}, ".env", false},
+		{"denied", protectedFS{deny: []string{"*.key"}}, "certs/server.key", true},
+		{"in denied directory", protectedFS{deny: []string{"secrets"}}, "secrets/a.txt", true},
+		{"allowed dotfile", protectedFS{allow: []string{".well-known"}}, ".well-known/security.txt", false},
+		{"allowed over deny", protectedFS{deny: []string{"*.txt"}, allow: []string{"robots.txt"}}, "robots.txt", false},
+		{"allow is not deny", protectedFS{allow: []string{"robots.txt"}}, "other.txt", false},
+		{"denied in other case", protectedFS{deny: []string{"*.key"}}, "server.KEY", true},
+		{"denied directory in other case", protectedFS{deny: []string{"secrets/**"}}, "Secrets/a", true},
+		{"denied pattern in other case", protectedFS{deny: []string{"*.PEM"}}, "certs/ca.pem", true},
+		{"allowed in other case", protectedFS{allow: []string{".well-known"}}, ".Well-Known/security.txt", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.fsys.hidden(tt.path); got != tt.want {
+				t.Errorf("hidden(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProtectedFSReadDir(t *testing.T) {
+	fsys := protectedFS{
+		fsys: fstest.MapFS{
+			"index.html":  {},
+			".env":        {},
+			"server.key":  {},
+			".git/config": {},
+			"js/app.js":   {},
+		},
+		deny: []string{"*.key"},
+	}
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if want := []string{"index.html", "js"}; !slices.Equal(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+
+	if _, err := fsys.Open(".env"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("open .env: got %v, want fs.ErrNotExist", err)
+	}
+}
@@ -0,0 +1,167 @@
+package httpwatch
+
+import (
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// idleBucketTimeout is how long the bucket of a client that stopped sending
+// requests is kept.
+const idleBucketTimeout = time.Minute
+
+// clientIP returns the address requests are limited by. Requests over a unix
+// socket come from a local reverse proxy, which can only be told apart by the
+// X-Real-IP or X-Forwarded-For header it sets, and otherwise share a single
+// "unix" client.
+func clientIP(r *http.Request) string {
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok && addr.Network() == "unix" {
+		if ip := r.Header.Get("X-Real-IP"); ip != "" {
+			return ip
+		}
+		// the last entry is the one added by the proxy
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			hops := strings.Split(forwarded[len(forwarded)-1], ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+				return ip
+			}
+		}
+		return "unix"
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type RateLimitConfig struct {
+	// Rate is the number of requests per second allowed per client IP.
+	Rate float64
+	// Burst is the number of requests allowed at once, defaults to Rate.
+	Burst int
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	// limited is set while requests are rejected, to log once per burst
+	limited bool
+}
+
+// rateLimiter keeps a token bucket per client IP.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+func newRateLimiter(cfg RateLimitConfig) *rateLimiter {
+	burst := float64(cfg.Burst)
+	if burst <= 0 {
+		burst = math.Max(cfg.Rate, 1)
+	}
+	return &rateLimiter{
+		rate:      cfg.Rate,
+		burst:     burst,
+		buckets:   map[string]*tokenBucket{},
+		lastPrune: time.Now(),
+	}
+}
+
+// take reports if ip may make a request at now, and else how long until it
+// may retry and if it is the first rejected request of the burst.
+func (l *rateLimiter) take(ip string, now time.Time) (bool, time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastPrune) > idleBucketTimeout {
+		for ip, b := range l.buckets {
+			if now.Sub(b.updated) > idleBucketTimeout {
+				delete(l.buckets, ip)
+			}
+		}
+		l.lastPrune = now
+	}
+
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[ip] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*l.rate)
+	b.updated = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		b.limited = false
+		return true, 0, false
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	first := !b.limited
+	b.limited = true
+	return false, wait, first
+}
+
+// NewRateLimitMiddleware returns a middleware limiting the requests of every
+// client IP with a token bucket, rejecting requests over the limit with 429.
+func NewRateLimitMiddleware(cfg RateLimitConfig) func(http.Handler) http.Handler {
+	limiter := newRateLimiter(cfg)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := clientIP(r)
+			ok, wait, first := limiter.take(ip, time.Now())
+			if ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if first {
+				slog.WarnContext(r.Context(), "rate limit reached", "remote_ip", ip, "rate", limiter.rate, "burst", limiter.burst)
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+		})
+	}
+}
+
+// connLimiter counts the open connections of every client IP.
+type connLimiter struct {
+	max   int
+	mu    sync.Mutex
+	conns map[string]int
+}
+
+func newConnLimiter(limit int) *connLimiter {
+	return &connLimiter{max: limit, conns: map[string]int{}}
+}
+
+// acquire reports if ip may open another connection, which must be
+// released when it closes.
+func (l *connLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.max > 0 && l.conns[ip] >= l.max {
+		return false
+	}
+	l.conns[ip]++
+	return true
+}
+
+func (l *connLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conns[ip]--; l.conns[ip] <= 0 {
+		delete(l.conns, ip)
+	}
+}
@@ -0,0 +1,129 @@
+package httpwatch
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterTake(t *testing.T) {
+	start := time.Now()
+
+	type request struct {
+		ip    string
+		after time.Duration
+		ok    bool
+		wait  time.Duration
+		first bool
+	}
+	tests := []struct {
+		name     string
+		cfg      RateLimitConfig
+		requests []request
+	}{
+		{
+			name: "burst then limited",
+			cfg:  RateLimitConfig{Rate: 1, Burst: 2},
+			requests: []request{
+				{ip: "a", ok: true},
+				{ip: "a", ok: true},
+				{ip: "a", wait: time.Second, first: true},
+				{ip: "a", wait: time.Second},
+			},
+		},
+		{
+			name: "refills over time",
+			cfg:  RateLimitConfig{Rate: 2, Burst: 1},
+			requests: []request{
+				{ip: "a", ok: true},
+				{ip: "a", after: 250 * time.Millisecond, wait: 250 * time.Millisecond, first: true},
+				{ip: "a", after: 250 * time.Millisecond, ok: true},
+				{ip: "a", wait: 500 * time.Millisecond, first: true},
+			},
+		},
+		{
+			name: "burst defaults to rate",
+			cfg:  RateLimitConfig{Rate: 3},
+			requests: []request{
+				{ip: "a", ok: true},
+				{ip: "a", ok: true},
+				{ip: "a", ok: true},
+				{ip: "a", wait: time.Second / 3, first: true},
+			},
+		},
+		{
+			name: "buckets per ip",
+			cfg:  RateLimitConfig{Rate: 1, Burst: 1},
+			requests: []request{
+				{ip: "a", ok: true},
+				{ip: "a", wait: time.Second, first: true},
+				{ip: "b", ok: true},
+			},
+		},
+		{
+			name: "refill is capped at burst",
+			cfg:  RateLimitConfig{Rate: 1, Burst: 1},
+			requests: []request{
+				{ip: "a", ok: true},
+				{ip: "a", after: time.Hour, ok: true},
+				{ip: "a", wait: time.Second, first: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRateLimiter(tt.cfg)
+			now := start
+			for i, req := range tt.requests {
+				now = now.Add(req.after)
+				ok, wait, first := l.take(req.ip, now)
+				if ok != req.ok || first != req.first {
+					t.Fatalf("request %d: got ok=%v first=%v, want ok=%v first=%v", i, ok, first, req.ok, req.first)
+				}
+				// float rounding may be off by a few nanoseconds
+				if diff := wait - req.wait; diff < -time.Microsecond || diff > time.Microsecond {
+					t.Fatalf("request %d: got wait %v, want %v", i, wait, req.wait)
+				}
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	unix := &net.UnixAddr{Name: "/run/http-watch.sock", Net: "unix"}
+	tcp := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080}
+
+	tests := []struct {
+		name       string
+		local      net.Addr
+		remoteAddr string
+		header     http.Header
+		want       string
+	}{
+		{"tcp", tcp, "192.0.2.1:5000", nil, "192.0.2.1"},
+		{"tcp ignores headers", tcp, "192.0.2.1:5000", http.Header{"X-Real-Ip": {"198.51.100.1"}}, "192.0.2.1"},
+		{"ipv6", tcp, "[2001:db8::1]:5000", nil, "2001:db8::1"},
+		{"unix", unix, "@", nil, "unix"},
+		{"unix real ip", unix, "@", http.Header{"X-Real-Ip": {"198.51.100.1"}}, "198.51.100.1"},
+		{"unix forwarded", unix, "@", http.Header{"X-Forwarded-For": {"203.0.113.9, 198.51.100.2"}}, "198.51.100.2"},
+		{"unix forwarded twice", unix, "@", http.Header{"X-Forwarded-For": {"203.0.113.9", "198.51.100.3"}}, "198.51.100.3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r = r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, tt.local))
+			r.RemoteAddr = tt.remoteAddr
+			for key, values := range tt.header {
+				r.Header[key] = values
+			}
+			if got := clientIP(r); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package httpwatch
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// SHA-crypt ($5$ and $6$ hashes) as specified at
+// https://www.akkadia.org/drepper/SHA-crypt.txt, the SHA based schemes
+// produced by htpasswd, mkpasswd and openssl passwd.
+
+const (
+	shaCryptDefaultRounds = 5000
+	shaCryptMinRounds     = 1000
+	shaCryptMaxRounds     = 999_999_999
+	shaCryptMaxSalt       = 16
+	shaCryptAlphabet      = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+// shaCryptOrder is the byte order of the encoded digest, three bytes per
+// group of four characters.
+var (
+	sha256CryptOrder = [][3]int{
+		{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+		{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29},
+	}
+	sha512CryptOrder = [][3]int{
+		{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+		{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+		{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+		{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+		{62, 20, 41},
+	}
+)
+
+// shaCrypt hashes password with the settings of the existing hash, in the
+// form $5$[rounds=N$]salt$digest.
+func shaCrypt(password, setting string) (string, error) {
+	var newHash func() hash.Hash
+	var order [][3]int
+	prefix := setting[:min(3, len(setting))]
+	switch prefix {
+	case "$5$":
+		newHash, order = sha256.New, sha256CryptOrder
+	case "$6$":
+		newHash, order = sha512.New, sha512CryptOrder
+	default:
+		return "", errors.New("not a SHA-crypt hash")
+	}
+
+	params := setting[3:]
+	rounds, customRounds := shaCryptDefaultRounds, false
+	if value, ok := strings.CutPrefix(params, "rounds="); ok {
+		n, rest, found := strings.Cut(value, "$")
+		if !found {
+			return "", errors.New("invalid rounds")
+		}
+		parsed, err := strconv.Atoi(n)
+		if err != nil {
+			return "", errors.New("invalid rounds")
+		}
+		rounds = min(max(parsed, shaCryptMinRounds), shaCryptMaxRounds)
+		customRounds = true
+		params = rest
+	}
+	salt, _, _ := strings.Cut(params, "$")
+	salt = salt[:min(len(salt), shaCryptMaxSalt)]
+
+	digest := shaCryptDigest(newHash, []byte(password), []byte(salt), rounds)
+
+	var b strings.Builder
+	b.WriteString(prefix)
+	if customRounds {
+		b.WriteString("rounds=" + strconv.Itoa(rounds) + "$")
+	}
+	b.WriteString(salt + "$")
+	for _, group := range order {
+		encode24(&b, digest[group[0]], digest[group[1]], digest[group[2]], 4)
+	}
+	if len(digest) == sha256.Size {
+		encode24(&b, 0, digest[31], digest[30], 3)
+	} else {
+		encode24(&b, 0, 0, digest[63], 2)
+	}
+	return b.String(), nil
+}
+
+func shaCryptDigest(newHash func() hash.Hash, password, salt []byte, rounds int) []byte {
+	h := newHash()
+	h.Write(password)
+	h.Write(salt)
+	h.Write(password)
+	alternate := h.Sum(nil)
+
+	h = newHash()
+	h.Write(password)
+	h.Write(salt)
+	h.Write(repeatBytes(alternate, len(password)))
+	for n := len(password); n > 0; n >>= 1 {
+		if n&1 == 1 {
+			h.Write(alternate)
+		} else {
+			h.Write(password)
+		}
+	}
+	digest := h.Sum(nil)
+
+	h = newHash()
+	for range len(password) {
+		h.Write(password)
+	}
+	p := repeatBytes(h.Sum(nil), len(password))
+
+	h = newHash()
+	for range 16 + int(digest[0]) {
+		h.Write(salt)
+	}
+	s := repeatBytes(h.Sum(nil), len(salt))
+
+	for i := range rounds {
+		h = newHash()
+		if i&1 == 1 {
+			h.Write(p)
+		} else {
+			h.Write(digest)
+		}
+		if i%3 != 0 {
+			h.Write(s)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i&1 == 1 {
+			h.Write(digest)
+		} else {
+			h.Write(p)
+		}
+		digest = h.Sum(digest[:0])
+	}
+	return digest
+}
+
+// repeatBytes repeats b up to n bytes.
+func repeatBytes(b []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		out = append(out, b[:min(len(b), n-len(out))]...)
+	}
+	return out
+}
+
+func encode24(b *strings.Builder, b2, b1, b0 byte, n int) {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	for range n {
+		b.WriteByte(shaCryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+}
@@ -0,0 +1,89 @@
+package httpwatch
+
+import "testing"
+
+// Test vectors from https://www.akkadia.org/drepper/SHA-crypt.txt
+func TestShaCrypt(t *testing.T) {
+	tests := []struct {
+		setting  string
+		password string
+		want     string
+	}{
+		{
+			setting:  "$5$saltstring",
+			password: "Hello world!",
+			want:     "$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5",
+		},
+		{
+			setting:  "$5$rounds=10000$saltstringsaltstring",
+			password: "Hello world!",
+			want:     "$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA",
+		},
+		{
+			setting:  "$5$rounds=5000$toolongsaltstring",
+			password: "This is just a test",
+			want:     "$5$rounds=5000$toolongsaltstrin$Un/5jzAHMgOGZ5.mWJpuVolil07guHPvOW8mGRcvxa5",
+		},
+		{
+			setting:  "$5$rounds=10$roundstoolow",
+			password: "the minimum number is still observed",
+			want:     "$5$rounds=1000$roundstoolow$yfvwcWrQ8l/K0DAWyuPMDNHpIVlTQebY9l/gL972bIC",
+		},
+		{
+			setting:  "$6$saltstring",
+			password: "Hello world!",
+			want:     "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1",
+		},
+		{
+			setting:  "$6$rounds=10000$saltstringsaltstring",
+			password: "Hello world!",
+			want:     "$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v.",
+		},
+		{
+			setting:  "$6$rounds=10$roundstoolow",
+			password: "the minimum number is still observed",
+			want:     "$6$rounds=1000$roundstoolow$kUMsbe306n21p9R.FRkW3IGn.S9NPN0x50YhH1xhLsPuWGsUSklZt58jaTfF4ZEQpyUNGc0dqbpBYYBaHHrsX.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.setting, func(t *testing.T) {
+			got, err := shaCrypt(tt.password, tt.setting)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShaCryptInvalid(t *testing.T) {
+	for _, setting := range []string{"", "$1$salt", "{SHA}abc", "$5$rounds=x$salt", "$6$rounds=1000"} {
+		if _, err := shaCrypt("password", setting); err == nil {
+			t.Errorf("%q: expected an error", setting)
+		}
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	tests := []struct {
+		password string
+		hash     string
+		want     bool
+	}{
+		{"secret", "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", true},
+		{"Secret", "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", false},
+		{"hunter2", "$6$saltsaltsaltsalt$dJX0KuyM7nCXuBPN.nYtdrJDotQEB1rFBumzix6FHzliKxLBinGY49pMJNvCoNf9fHmNyZ1IgX/glbcKhuXoh.", true},
+		{"hunter3", "$6$saltsaltsaltsalt$dJX0KuyM7nCXuBPN.nYtdrJDotQEB1rFBumzix6FHzliKxLBinGY49pMJNvCoNf9fHmNyZ1IgX/glbcKhuXoh.", false},
+		{"secret", "$5$rounds=1200$xyzsalt$CqYPJFUfFuvx/Y3BbisFTH2/ImAsi4cxnoHXPMzqJ79", true},
+		{"secret", "$1$unsupported", false},
+	}
+
+	for _, tt := range tests {
+		if got := checkPassword(tt.password, tt.hash); got != tt.want {
+			t.Errorf("checkPassword(%q, %q) = %v, want %v", tt.password, tt.hash, got, tt.want)
+		}
+	}
+}
@@ -2,147 +2,723 @@ package httpwatch
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 )
 
+// watchStats holds the state of every running watcher.
+var watchStats = &statsRegistry{watchers: map[string]*watcherStats{}}
+
+// watcherStats is the state of a running watcher, updated by its Run loop.
+type watcherStats struct {
+	dirs, recentEvents, hashes, pendingRemoves, pendingWrites atomic.Int64
+}
+
+// statsRegistry holds the stats of running watchers by a key unique to each.
+type statsRegistry struct {
+	mu       sync.Mutex
+	watchers map[string]*watcherStats
+}
+
+// add registers stats under name, suffixed with #2, #3 and so on when another
+// watcher uses the name already, and returns the key to remove them with.
+func (r *statsRegistry) add(name string, stats *watcherStats) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := name
+	for n := 2; r.watchers[key] != nil; n++ {
+		key = name + "#" + strconv.Itoa(n)
+	}
+	r.watchers[key] = stats
+	return key
+}
+
+func (r *statsRegistry) remove(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.watchers, key)
+}
+
+func (r *statsRegistry) MarshalJSON() ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make(map[string]map[string]int64, len(r.watchers))
+	for key, stats := range r.watchers {
+		out[key] = map[string]int64{
+			"dirs":            stats.dirs.Load(),
+			"recent_events":   stats.recentEvents.Load(),
+			"hashes":          stats.hashes.Load(),
+			"pending_removes": stats.pendingRemoves.Load(),
+			"pending_writes":  stats.pendingWrites.Load(),
+		}
+	}
+	return json.Marshal(out)
+}
+
+// atomicSaveWindow is how long a removed file may take to be replaced by an
+// atomic save before it is reported as removed.
+const atomicSaveWindow = 100 * time.Millisecond
+
+// writeSettleWindow is how long a file has to go without events before its
+// changes are hashed and reported. Rewriting a file in place truncates it
+// first, hashing right away would report the empty file.
+const writeSettleWindow = 50 * time.Millisecond
+
+// FileChange is the data of file.change messages.
+type FileChange struct {
+	// Root is the watched directory the change happened in, as configured.
+	Root string `json:"root"`
+	// Path is relative to Root.
+	Path string `json:"path"`
+	// Op is one of "create", "write" or "remove".
+	Op string `json:"op"`
+	// Hash is the sha256 of the file contents, empty for removed files.
+	Hash string `json:"hash,omitempty"`
+}
+
+// Actions a Rule can map changed files to, sent as the message type.
+const (
+	ActionFileChange = "file.change"
+	ActionReload     = "page.reload"
+	ActionCSS        = "css.update"
+	ActionAsset      = "asset.update"
+	ActionBuild      = "build"
+)
+
+// Rule maps the files matching Pattern to an action. Any action name is
+// accepted, clients ignore the ones they do not know.
+type Rule struct {
+	Pattern string
+	Action  string
+}
+
+type rule struct {
+	regex  *regexp.Regexp
+	action string
+}
+
 type WatcherConfig struct {
 	Recursive   bool
 	Dir         string
 	FilePattern string
+	// Rules are checked in order for files that changed, the first match sets
+	// the message type. Files matching no rule are sent as ActionFileChange,
+	// when FilePattern is empty only files matching a rule are sent.
+	Rules []Rule
+
+	// Backend is one of BackendAuto, BackendFsnotify or BackendPoll.
+	Backend string
+	// PollInterval is how often the poll backend scans for changes.
+	PollInterval time.Duration
+	// PollHash makes the poll backend compare file contents, not just mtime and size.
+	PollHash bool
+	// FollowSymlinks watches the targets of symlinked directories when recursive.
+	// Changes are reported under the symlinked path.
+	FollowSymlinks bool
 }
 
-func NewWatcherFn(ctx context.Context, cfg WatcherConfig, b *Broadcaster) (func(), error) {
-	watcher, err := fsnotify.NewWatcher()
+type watchedDir struct {
+	// name is the path the directory was added to the watcher with
+	name string
+	// real is the path with symlinks resolved, set when following symlinks
+	real string
+}
+
+// PatternError is returned by NewWatcher for an invalid FilePattern.
+type PatternError struct {
+	Pattern string
+	Err     error
+}
+
+func (e *PatternError) Error() string {
+	return fmt.Sprintf("invalid file pattern %q: %v", e.Pattern, e.Err)
+}
+
+func (e *PatternError) Unwrap() error {
+	return e.Err
+}
+
+// eventTimeout is how long repeated events for the same file and op are
+// treated as duplicates.
+const eventTimeout = 100 * time.Millisecond
+
+// Watcher watches a directory for changes to files matching a pattern and
+// broadcasts them as file.change messages.
+type Watcher struct {
+	cfg      WatcherConfig
+	b        *Broadcaster
+	regex    *regexp.Regexp
+	rules    []rule
+	fullPath string
+	errors   chan error
+
+	// mu guards replacing and closing the backend, which is only replaced
+	// from Run
+	mu      sync.Mutex
+	backend Backend
+	closed  bool
+
+	// Track watched directories by absolute path, and by real path when
+	// following symlinks to detect cycles
+	watchedDirs map[string]watchedDir
+	watchedReal map[string]bool
+
+	// Track recently processed events to avoid duplicates
+	// This is useful because some file operations can trigger multiple events
+	recentEvents map[string]time.Time
+
+	// Remember file hashes to skip writes that did not change the contents,
+	// and the files seen while walking the watched directories to report
+	// files replaced by a rename as written rather than created
+	hashes *hashCache
+
+	// Removed or renamed files waiting to see if an atomic save replaces them
+	pendingRemoves map[string]time.Time
+	flushRemoves   <-chan time.Time
+
+	// Created or written files waiting for their writes to settle
+	pendingWrites map[string]*pendingWrite
+	flushWrites   <-chan time.Time
+}
+
+type pendingWrite struct {
+	change FileChange
+	action string
+	last   time.Time
+}
+
+// NewWatcher creates a Watcher and adds cfg.Dir to it. Invalid patterns
+// are reported as a *PatternError.
+func NewWatcher(cfg WatcherConfig, b *Broadcaster) (*Watcher, error) {
+	regex, err := regexp.Compile(cfg.FilePattern)
 	if err != nil {
-		slog.ErrorContext(ctx, "Failed to create watcher", "error", err)
-		os.Exit(1)
+		return nil, &PatternError{Pattern: cfg.FilePattern, Err: err}
+	}
+	if cfg.FilePattern == "" && len(cfg.Rules) > 0 {
+		regex = nil
 	}
 
-	addDir := func(path string) error {
-		if cfg.Recursive {
-			return filepath.Walk(path, func(walkPath string, info os.FileInfo, err error) error {
-				if err != nil {
-					return err
-				}
-				if info.IsDir() {
-					if err := watcher.Add(walkPath); err != nil {
-						return err
-					}
+	rules := make([]rule, 0, len(cfg.Rules))
+	for _, r := range cfg.Rules {
+		if r.Action == "" {
+			return nil, fmt.Errorf("rule for pattern %q has no action", r.Pattern)
+		}
+		ruleRegex, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, &PatternError{Pattern: r.Pattern, Err: err}
+		}
+		rules = append(rules, rule{regex: ruleRegex, action: r.Action})
+	}
+
+	fullPath := cfg.Dir
+	if fullPath != "" {
+		fullPath, err = filepath.Abs(cfg.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed getting path: %w", err)
+		}
+	}
+
+	backend, err := newBackend(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating watcher: %w", err)
+	}
+
+	w := &Watcher{
+		cfg:            cfg,
+		b:              b,
+		regex:          regex,
+		rules:          rules,
+		fullPath:       fullPath,
+		errors:         make(chan error, 16),
+		backend:        backend,
+		watchedDirs:    make(map[string]watchedDir),
+		watchedReal:    make(map[string]bool),
+		recentEvents:   make(map[string]time.Time),
+		hashes:         newHashCache(maxHashEntries),
+		pendingRemoves: make(map[string]time.Time),
+		pendingWrites:  make(map[string]*pendingWrite),
+	}
+
+	// Add the initial directory to the watcher
+	err = w.addDir(cfg.Dir)
+	if err != nil && shouldFallBack(cfg, w.backend, err) {
+		err = w.fallBack(context.Background(), err)
+	}
+	if err != nil {
+		_ = w.Close()
+		return nil, fmt.Errorf("failed adding directory to watcher: %w", err)
+	}
+	return w, nil
+}
+
+// Errors returns the errors reported by the backend while running. Errors
+// are dropped when nobody is receiving, the channel is closed once Run returns.
+func (w *Watcher) Errors() <-chan error {
+	return w.errors
+}
+
+// Close stops the watcher, making Run return.
+func (w *Watcher) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.backend.Close()
+}
+
+func (w *Watcher) watchDir(path, real string) error {
+	if err := w.backend.Add(path); err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	w.watchedDirs[abs] = watchedDir{name: path, real: real}
+	if real != "" {
+		w.watchedReal[real] = true
+	}
+	return nil
+}
+
+// seedFile remembers the watched file at the absolute path abs as existing,
+// while walking directories.
+func (w *Watcher) seedFile(abs string) {
+	if name := filepath.Base(abs); !isTempFile(name) && w.action(name) != "" {
+		w.hashes.seed(abs)
+	}
+}
+
+// walkFollow watches path and its subdirectories, following symlinked
+// directories only once the real directories below path are watched, so
+// directories reachable both ways are reported under their real path.
+// Directories already watched through another path, like a symlink back to
+// a parent, are skipped.
+func (w *Watcher) walkFollow(path string) error {
+	var links []string
+	if err := w.walkReal(path, &links); err != nil {
+		return err
+	}
+	for _, link := range links {
+		if err := w.walkFollow(link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkReal watches path and its subdirectories that aren't symlinks,
+// collecting the symlinked directories in links.
+func (w *Watcher) walkReal(path string, links *[]string) error {
+	// resolved from an absolute path, a relative root would resolve to "."
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	real, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return err
+	}
+	if w.watchedReal[real] {
+		return nil
+	}
+	if err := w.watchDir(path, real); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		child := filepath.Join(path, entry.Name())
+		if entry.Type()&fs.ModeSymlink != 0 {
+			// broken links and links to files are skipped
+			if info, err := os.Stat(child); err == nil && info.IsDir() {
+				*links = append(*links, child)
+			}
+			continue
+		}
+		if !entry.IsDir() {
+			if entry.Type().IsRegular() {
+				w.seedFile(filepath.Join(abs, entry.Name()))
+			}
+			continue
+		}
+		if err := w.walkReal(child, links); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Watcher) addDir(path string) error {
+	if w.cfg.Recursive && w.cfg.FollowSymlinks {
+		return w.walkFollow(path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	return w.walkDir(path, abs, w.cfg.Recursive)
+}
+
+// walkDir watches path, whose absolute path is abs, and its subdirectories
+// when recursive, without following symlinks.
+func (w *Watcher) walkDir(path, abs string, recursive bool) error {
+	if err := w.watchDir(path, ""); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		switch {
+		case entry.IsDir() && recursive:
+			err := w.walkDir(filepath.Join(path, entry.Name()), filepath.Join(abs, entry.Name()), true)
+			if err != nil {
+				return err
+			}
+		case entry.Type().IsRegular():
+			w.seedFile(filepath.Join(abs, entry.Name()))
+		}
+	}
+	return nil
+}
+
+// removeDir stops watching a removed or renamed directory and everything
+// below it, reporting the files known to be in it as removed. Not every
+// backend reports them itself, fsnotify doesn't when a directory is moved.
+func (w *Watcher) removeDir(path string) {
+	for dir, watched := range w.watchedDirs {
+		if dir == path || strings.HasPrefix(dir, path+string(filepath.Separator)) {
+			// deleted directories are already gone from the watcher
+			_ = w.backend.Remove(watched.name)
+			delete(w.watchedDirs, dir)
+			delete(w.watchedReal, watched.real)
+		}
+	}
+	for _, file := range w.hashes.below(path) {
+		w.queueRemove(file)
+	}
+}
+
+// fallBack replaces fsnotify with polling once the inotify watch limit is hit.
+func (w *Watcher) fallBack(ctx context.Context, err error) error {
+	slog.WarnContext(ctx, "fsnotify watch limit reached, falling back to polling", "error", err)
+
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	_ = w.backend.Close()
+	w.backend = newPollWatcherFromConfig(w.cfg)
+	w.mu.Unlock()
+
+	clear(w.watchedDirs)
+	clear(w.watchedReal)
+	return w.addDir(w.cfg.Dir)
+}
+
+func (w *Watcher) relPath(filePath string) string {
+	return strings.ReplaceAll(filePath, w.fullPath, "")
+}
+
+// Run handles file events until ctx is done or the watcher is closed. It
+// returns an error when the backend stops on its own or a directory can no
+// longer be watched.
+func (w *Watcher) Run(ctx context.Context) error {
+	defer close(w.errors)
+	defer func() { _ = w.Close() }()
+
+	// named like -watch flags, the same directory may be watched with several patterns
+	stats := &watcherStats{}
+	statsKey := watchStats.add(w.fullPath+":"+w.cfg.FilePattern, stats)
+	defer watchStats.remove(statsKey)
+
+	pruneTicker := time.NewTicker(time.Minute)
+	defer pruneTicker.Stop()
+
+	slog.InfoContext(ctx, "started watching for files", "pattern", w.cfg.FilePattern)
+	for {
+		stats.dirs.Store(int64(len(w.watchedDirs)))
+		stats.recentEvents.Store(int64(len(w.recentEvents)))
+		stats.hashes.Store(int64(w.hashes.ll.Len()))
+		stats.pendingRemoves.Store(int64(len(w.pendingRemoves)))
+		stats.pendingWrites.Store(int64(len(w.pendingWrites)))
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-pruneTicker.C:
+			for key, lastEvent := range w.recentEvents {
+				if time.Since(lastEvent) >= eventTimeout {
+					delete(w.recentEvents, key)
 				}
-				return nil
-			})
-		} else {
-			if err := watcher.Add(path); err != nil {
+			}
+		case event, ok := <-w.backend.Events():
+			if !ok {
+				return w.stopped()
+			}
+			if err := w.handleFsEvent(ctx, event); err != nil {
 				return err
 			}
+		case <-w.flushRemoves:
+			w.flushPendingRemoves(ctx)
+		case <-w.flushWrites:
+			w.flushPendingWrites(ctx)
+		case err, ok := <-w.backend.Errors():
+			if !ok {
+				return w.stopped()
+			}
+			slog.DebugContext(ctx, "watcher error", "error", err)
+			select {
+			case w.errors <- err:
+			default:
+			}
 		}
+	}
+}
+
+// stopped returns the error for the backend channels being closed, which
+// is expected only after Close.
+func (w *Watcher) stopped() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
 		return nil
 	}
+	return errors.New("watcher backend stopped unexpectedly")
+}
 
-	// Add the initial directory to the watcher
-	if err := addDir(cfg.Dir); err != nil {
-		return nil, fmt.Errorf("failed adding directory to watcher: %w", err)
+// handleFsEvent returns an error only when the watcher can't go on.
+func (w *Watcher) handleFsEvent(ctx context.Context, event fsnotify.Event) error {
+	// Get the absolute file path for consistent handling
+	filePath, err := filepath.Abs(event.Name)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting absolute path", "error", err)
+		return nil
 	}
 
-	// Create a map to track recently processed events to avoid duplicates
-	// This is useful because some file operations can trigger multiple events
-	recentEvents := make(map[string]time.Time)
-	const eventTimeout = 100 * time.Millisecond
+	// Check if this is a recent duplicate event. Writes are left to the
+	// hash comparison below, dropping them here could lose the last one.
+	if event.Op != fsnotify.Write {
+		lastEvent, exists := w.recentEvents[filePath+string(rune(event.Op))]
+		if exists && time.Since(lastEvent) < eventTimeout {
+			return nil
+		}
+		w.recentEvents[filePath+string(rune(event.Op))] = time.Now()
+	}
 
-	// Compile the regex pattern
-	regex := regexp.MustCompile(cfg.FilePattern)
+	// Stop watching directories that were removed or moved away
+	if _, ok := w.watchedDirs[filePath]; ok &&
+		(event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename)) {
+		w.removeDir(filePath)
+		return nil
+	}
 
-	fullPath := cfg.Dir
-	if fullPath != "" {
-		var err error
-		fullPath, err = filepath.Abs(cfg.Dir)
+	// Get file info to check if it's a directory
+	fileInfo, err := os.Stat(filePath)
+	isDir := err == nil && fileInfo.IsDir()
+
+	// If a new directory is created and we're in recursive mode, watch it
+	if isDir && w.cfg.Recursive && (event.Op&fsnotify.Create == fsnotify.Create) {
+		err := w.addDir(filePath)
+		if err != nil && shouldFallBack(w.cfg, w.backend, err) {
+			if err := w.fallBack(ctx, err); err != nil {
+				return fmt.Errorf("failed falling back to polling: %w", err)
+			}
+			return nil
+		}
 		if err != nil {
-			return nil, fmt.Errorf("failed getting path: %w", err)
+			// the directory may be gone already
+			slog.ErrorContext(ctx, "Error adding new directory to watcher", "error", err)
 		}
+		return nil
 	}
 
-	return func() {
-		defer watcher.Close()
+	// Skip directory events for filtering
+	if isDir {
+		return nil
+	}
 
-		slog.InfoContext(ctx, "started watching for files", "pattern", cfg.FilePattern)
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case event, ok := <-watcher.Events:
-				if !ok {
-					return
-				}
+	// Check if the file matches our pattern, ignoring the temp and
+	// backup files editors create while saving
+	filename := filepath.Base(filePath)
+	if isTempFile(filename) || w.action(filename) == "" {
+		return nil
+	}
 
-				// Get the absolute file path for consistent handling
-				filePath, err := filepath.Abs(event.Name)
-				if err != nil {
-					slog.ErrorContext(ctx, "Error getting absolute path", "error", err)
-					continue
-				}
+	// An atomic save renames or removes the original and moves a temp
+	// file in its place, wait to see if the file comes back
+	if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		if pending, ok := w.pendingWrites[filePath]; ok && pending.change.Op == "create" {
+			// created and gone again before it was reported
+			delete(w.pendingWrites, filePath)
+			w.hashes.remove(filePath)
+			return nil
+		}
+		w.queueRemove(filePath)
+		return nil
+	}
 
-				// Check if this is a recent duplicate event
-				lastEvent, exists := recentEvents[filePath+string(rune(event.Op))]
-				if exists && time.Since(lastEvent) < eventTimeout {
-					continue
-				}
-				recentEvents[filePath+string(rune(event.Op))] = time.Now()
-
-				// Get file info to check if it's a directory
-				fileInfo, err := os.Stat(filePath)
-				isDir := err == nil && fileInfo.IsDir()
-
-				// If a new directory is created and we're in recursive mode, watch it
-				if isDir && cfg.Recursive && (event.Op&fsnotify.Create == fsnotify.Create) {
-					if err := addDir(filePath); err != nil {
-						slog.ErrorContext(ctx, "Error adding new directory to watcher", "error", err)
-					}
-					continue
-				}
+	change := FileChange{Root: w.cfg.Dir, Path: w.relPath(filePath)}
+	switch {
+	case event.Has(fsnotify.Create):
+		change.Op = "create"
+	case event.Has(fsnotify.Write):
+		change.Op = "write"
+	default:
+		// permission changes
+		return nil
+	}
+	if _, ok := w.pendingRemoves[filePath]; ok {
+		delete(w.pendingRemoves, filePath)
+		change.Op = "write"
+	} else if w.hashes.has(filePath) {
+		// a temp file was renamed over a file that already existed
+		change.Op = "write"
+	}
 
-				// Skip directory events for filtering
-				if isDir {
-					continue
-				}
+	// a create followed by writes is reported once, as create
+	if pending, ok := w.pendingWrites[filePath]; ok {
+		pending.last = time.Now()
+		if change.Op == "create" {
+			pending.change.Op = change.Op
+		}
+		return nil
+	}
+	w.pendingWrites[filePath] = &pendingWrite{change: change, action: w.action(filename), last: time.Now()}
+	if w.flushWrites == nil {
+		w.flushWrites = time.After(writeSettleWindow)
+	}
+	return nil
+}
 
-				// Check if the file matches our pattern
-				filename := filepath.Base(filePath)
-				if !regex.MatchString(filename) {
-					continue
-				}
+// flushPendingWrites reports the files without events for
+// writeSettleWindow, unless their contents are unchanged.
+func (w *Watcher) flushPendingWrites(ctx context.Context) {
+	w.flushWrites = nil
+	for filePath, pending := range w.pendingWrites {
+		if time.Since(pending.last) < writeSettleWindow {
+			continue
+		}
+		delete(w.pendingWrites, filePath)
+
+		change := pending.change
+		hash, err := hashFile(filePath)
+		if errors.Is(err, fs.ErrNotExist) {
+			// removed again, left to the pending remove
+			continue
+		}
+		if err == nil {
+			if !w.hashes.update(filePath, hash) {
+				slog.DebugContext(ctx, "file contents unchanged", "file", change.Path)
+				continue
+			}
+			change.Hash = hash
+		}
+		handleEvent(ctx, pending.action, change, w.b)
+	}
+	if len(w.pendingWrites) > 0 {
+		w.flushWrites = time.After(writeSettleWindow)
+	}
+}
 
-				path := strings.ReplaceAll(filePath, fullPath, "")
-				handleEvent(ctx, event, path, b)
+// action returns the message type for a changed file, empty when the file
+// is not watched.
+func (w *Watcher) action(filename string) string {
+	for _, r := range w.rules {
+		if r.regex.MatchString(filename) {
+			return r.action
+		}
+	}
+	if w.regex != nil && w.regex.MatchString(filename) {
+		return ActionFileChange
+	}
+	return ""
+}
 
-			case err, ok := <-watcher.Errors:
-				if !ok {
-					return
-				}
-				slog.ErrorContext(ctx, "watcher error", "error", err)
+// queueRemove reports filePath as removed unless it is replaced within
+// atomicSaveWindow.
+func (w *Watcher) queueRemove(filePath string) {
+	if _, ok := w.pendingRemoves[filePath]; !ok {
+		w.pendingRemoves[filePath] = time.Now()
+	}
+	if w.flushRemoves == nil {
+		w.flushRemoves = time.After(atomicSaveWindow)
+	}
+}
+
+// flushPendingRemoves reports removed files that were not replaced within
+// atomicSaveWindow.
+func (w *Watcher) flushPendingRemoves(ctx context.Context) {
+	w.flushRemoves = nil
+	for filePath, removed := range w.pendingRemoves {
+		if time.Since(removed) < atomicSaveWindow {
+			continue
+		}
+		delete(w.pendingRemoves, filePath)
+
+		change := FileChange{Root: w.cfg.Dir, Path: w.relPath(filePath), Op: "remove"}
+		// replaced without a create event reaching us, e.g. when polling
+		if hash, err := hashFile(filePath); err == nil {
+			if !w.hashes.update(filePath, hash) {
+				continue
 			}
+			change.Op = "write"
+			change.Hash = hash
+		} else {
+			w.hashes.remove(filePath)
 		}
-	}, nil
+		handleEvent(ctx, w.action(filepath.Base(filePath)), change, w.b)
+	}
+	if len(w.pendingRemoves) > 0 {
+		w.flushRemoves = time.After(atomicSaveWindow)
+	}
+}
+
+func handleEvent(ctx context.Context, action string, change FileChange, b *Broadcaster) {
+	slog.DebugContext(ctx, "file changed", "file", change.Path, "op", change.Op, "action", action)
+	b.Broadcast(Message{Type: action, Data: change})
 }
 
-func handleEvent(ctx context.Context, event fsnotify.Event, filePath string, b *Broadcaster) {
+// tempFileSuffix matches the temp files atomic writers rename over the
+// original, e.g. index.html.tmp or index.html.tmp.1234.
+var tempFileSuffix = regexp.MustCompile(`\.(tmp|temp)([.\-_][0-9a-zA-Z]+|[0-9]+)?$`)
+
+// isTempFile reports if name looks like an editor backup, swap or atomic save
+// temp file.
+func isTempFile(name string) bool {
 	switch {
-	case event.Op&fsnotify.Create == fsnotify.Create:
-	case event.Op&fsnotify.Write == fsnotify.Write:
-	case event.Op&fsnotify.Remove == fsnotify.Remove:
-	case event.Op&fsnotify.Rename == fsnotify.Rename:
-	case event.Op&fsnotify.Chmod == fsnotify.Chmod:
-		// eventType = "permission changed"
-		return
+	case name == "4913", // vim checks the directory is writable with this file
+		strings.HasSuffix(name, "~"),
+		strings.HasSuffix(name, ".swp"),
+		strings.HasSuffix(name, ".swx"),
+		strings.HasSuffix(name, "___jb_tmp___"),
+		strings.HasSuffix(name, "___jb_old___"),
+		strings.HasPrefix(name, ".#"),
+		strings.HasPrefix(name, "#") && strings.HasSuffix(name, "#"),
+		strings.HasPrefix(name, ".goutputstream-"):
+		return true
 	}
-
-	slog.DebugContext(ctx, "file changed", "file", filePath)
-	b.Broadcast(filePath)
+	return tempFileSuffix.MatchString(name)
 }
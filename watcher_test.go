@@ -0,0 +1,349 @@
+package httpwatch
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestNewWatcherPatternError(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         WatcherConfig
+		wantPattern string
+	}{
+		{
+			name:        "file pattern",
+			cfg:         WatcherConfig{FilePattern: "(.*"},
+			wantPattern: "(.*",
+		},
+		{
+			name:        "rule pattern",
+			cfg:         WatcherConfig{FilePattern: ".*", Rules: []Rule{{Pattern: `.*\.css$`, Action: ActionCSS}, {Pattern: "[a-", Action: ActionAsset}}},
+			wantPattern: "[a-",
+		},
+		{
+			name: "valid",
+			cfg:  WatcherConfig{FilePattern: `.*\.html`, Rules: []Rule{{Pattern: `.*\.css$`, Action: ActionCSS}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Dir = t.TempDir()
+			w, err := NewWatcher(tt.cfg, NewBroadcaster())
+			if tt.wantPattern == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				_ = w.Close()
+				return
+			}
+
+			var patternErr *PatternError
+			if !errors.As(err, &patternErr) {
+				t.Fatalf("got %v, want a *PatternError", err)
+			}
+			if patternErr.Pattern != tt.wantPattern {
+				t.Errorf("got pattern %q, want %q", patternErr.Pattern, tt.wantPattern)
+			}
+		})
+	}
+}
+
+func TestNewWatcherRuleWithoutAction(t *testing.T) {
+	_, err := NewWatcher(WatcherConfig{Dir: t.TempDir(), Rules: []Rule{{Pattern: ".*"}}}, NewBroadcaster())
+	var patternErr *PatternError
+	if err == nil || errors.As(err, &patternErr) {
+		t.Fatalf("got %v, want an error other than *PatternError", err)
+	}
+}
+
+// startWatcher runs a watcher for cfg until the test ends, returning a
+// subscriber receiving its messages.
+func startWatcher(t *testing.T, cfg WatcherConfig) Subscriber {
+	t.Helper()
+	b := NewBroadcaster()
+	sub := b.AddSubscriber()
+	w, err := NewWatcher(cfg, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- w.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run: %v", err)
+		}
+	})
+	return sub
+}
+
+// pollConfig watches dir with the poll backend, scanning often enough for
+// tests.
+func pollConfig(dir string) WatcherConfig {
+	return WatcherConfig{
+		Dir:          dir,
+		FilePattern:  `.*\.html$`,
+		Recursive:    true,
+		Backend:      BackendPoll,
+		PollInterval: 10 * time.Millisecond,
+	}
+}
+
+// nextChange returns the next file change sent to sub.
+func nextChange(t *testing.T, sub Subscriber) FileChange {
+	t.Helper()
+	select {
+	case msg := <-sub:
+		change, ok := msg.Data.(FileChange)
+		if !ok {
+			t.Fatalf("got %s message with %T data, want a FileChange", msg.Type, msg.Data)
+		}
+		return change
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a file change")
+		return FileChange{}
+	}
+}
+
+// expectChange waits for the next change and checks its path and op.
+func expectChange(t *testing.T, sub Subscriber, path, op string) {
+	t.Helper()
+	if got := nextChange(t, sub); got.Path != path || got.Op != op {
+		t.Fatalf("got %s %s, want %s %s", got.Op, got.Path, op, path)
+	}
+}
+
+// expectQuiet checks nothing is sent to sub for longer than the watcher
+// holds back changes.
+func expectQuiet(t *testing.T, sub Subscriber) {
+	t.Helper()
+	select {
+	case msg := <-sub:
+		t.Fatalf("got unexpected %s message: %+v", msg.Type, msg.Data)
+	case <-time.After(4 * atomicSaveWindow):
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func rename(t *testing.T, from, to string) {
+	t.Helper()
+	if err := os.Rename(from, to); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatcherPoll(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	sub := startWatcher(t, pollConfig(dir))
+
+	file := filepath.Join(dir, "sub", "index.html")
+	writeFile(t, file, "one")
+	expectChange(t, sub, "/sub/index.html", "create")
+
+	writeFile(t, file, "two, longer")
+	expectChange(t, sub, "/sub/index.html", "write")
+
+	// not matching the pattern
+	writeFile(t, filepath.Join(dir, "notes.txt"), "ignored")
+	expectQuiet(t, sub)
+
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(t, sub, "/sub/index.html", "remove")
+}
+
+func TestWatcherSkipsUnchangedContents(t *testing.T) {
+	dir := t.TempDir()
+	sub := startWatcher(t, pollConfig(dir))
+
+	file := filepath.Join(dir, "index.html")
+	writeFile(t, file, "same")
+	first := nextChange(t, sub)
+	if first.Op != "create" || first.Hash == "" {
+		t.Fatalf("got %+v, want a create with a hash", first)
+	}
+
+	// rewritten with the same contents, the poll backend sees the new mtime
+	writeFile(t, file, "same")
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	expectQuiet(t, sub)
+
+	writeFile(t, file, "different")
+	second := nextChange(t, sub)
+	if second.Op != "write" || second.Hash == "" || second.Hash == first.Hash {
+		t.Fatalf("got %+v, want a write with a new hash", second)
+	}
+}
+
+func TestWatcherAtomicSave(t *testing.T) {
+	tests := []struct {
+		name string
+		save func(t *testing.T, dir string)
+		want string
+	}{
+		{
+			name: "temp file renamed over the original",
+			save: func(t *testing.T, dir string) {
+				writeFile(t, filepath.Join(dir, "index.html.tmp"), "new contents")
+				rename(t, filepath.Join(dir, "index.html.tmp"), filepath.Join(dir, "index.html"))
+			},
+			want: "write",
+		},
+		{
+			name: "original moved to a backup first",
+			save: func(t *testing.T, dir string) {
+				rename(t, filepath.Join(dir, "index.html"), filepath.Join(dir, "index.html~"))
+				writeFile(t, filepath.Join(dir, "index.html"), "new contents")
+			},
+			want: "write",
+		},
+		{
+			name: "created and renamed away",
+			save: func(t *testing.T, dir string) {
+				writeFile(t, filepath.Join(dir, "draft.html"), "draft")
+				rename(t, filepath.Join(dir, "draft.html"), filepath.Join(dir, "draft.txt"))
+			},
+		},
+	}
+
+	for _, backend := range []string{BackendPoll, BackendFsnotify} {
+		for _, tt := range tests {
+			t.Run(backend+"/"+tt.name, func(t *testing.T) {
+				dir := t.TempDir()
+				writeFile(t, filepath.Join(dir, "index.html"), "old")
+				cfg := pollConfig(dir)
+				cfg.Backend = backend
+				sub := startWatcher(t, cfg)
+
+				tt.save(t, dir)
+				if tt.want != "" {
+					expectChange(t, sub, "/index.html", tt.want)
+				}
+				expectQuiet(t, sub)
+			})
+		}
+	}
+}
+
+func TestWatcherRemovedDir(t *testing.T) {
+	tests := []struct {
+		name   string
+		remove func(t *testing.T, dir string)
+	}{
+		{
+			name: "deleted",
+			remove: func(t *testing.T, dir string) {
+				if err := os.RemoveAll(dir); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "moved away",
+			remove: func(t *testing.T, dir string) {
+				rename(t, dir, filepath.Join(t.TempDir(), "moved"))
+			},
+		},
+	}
+
+	for _, backend := range []string{BackendPoll, BackendFsnotify} {
+		for _, tt := range tests {
+			t.Run(backend+"/"+tt.name, func(t *testing.T) {
+				dir := t.TempDir()
+				if err := os.MkdirAll(filepath.Join(dir, "sub", "deep"), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				writeFile(t, filepath.Join(dir, "sub", "b.html"), "b")
+				writeFile(t, filepath.Join(dir, "sub", "deep", "c.html"), "c")
+				cfg := pollConfig(dir)
+				cfg.Backend = backend
+				sub := startWatcher(t, cfg)
+
+				tt.remove(t, filepath.Join(dir, "sub"))
+				var got []string
+				for range 2 {
+					change := nextChange(t, sub)
+					got = append(got, change.Op+" "+change.Path)
+				}
+				slices.Sort(got)
+				if want := []string{"remove /sub/b.html", "remove /sub/deep/c.html"}; !slices.Equal(got, want) {
+					t.Fatalf("got %q, want %q", got, want)
+				}
+				expectQuiet(t, sub)
+
+				if dirs := watchedDirCount(t, dir, cfg.FilePattern); dirs != 1 {
+					t.Errorf("got %d watched directories, want 1", dirs)
+				}
+			})
+		}
+	}
+}
+
+// watchedDirCount returns the number of directories the running watcher
+// for dir and pattern reports in its stats.
+func watchedDirCount(t *testing.T, dir, pattern string) int64 {
+	t.Helper()
+	watchStats.mu.Lock()
+	defer watchStats.mu.Unlock()
+	stats, ok := watchStats.watchers[dir+":"+pattern]
+	if !ok {
+		t.Fatalf("no stats for %s", dir)
+	}
+	return stats.dirs.Load()
+}
+
+func TestWatcherFollowSymlinks(t *testing.T) {
+	dir, outside := t.TempDir(), t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "site"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"alias":     filepath.Join(dir, "site"), // reachable by its real path too
+		"site/loop": dir,                        // a cycle back to the root
+		"ext":       outside,                    // only reachable through the link
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := pollConfig(dir)
+	cfg.FollowSymlinks = true
+	sub := startWatcher(t, cfg)
+
+	writeFile(t, filepath.Join(dir, "site", "a.html"), "a")
+	expectChange(t, sub, "/site/a.html", "create")
+	expectQuiet(t, sub)
+
+	writeFile(t, filepath.Join(outside, "b.html"), "b")
+	expectChange(t, sub, "/ext/b.html", "create")
+	expectQuiet(t, sub)
+
+	// root, site and ext
+	if dirs := watchedDirCount(t, dir, cfg.FilePattern); dirs != 3 {
+		t.Errorf("got %d watched directories, want 3", dirs)
+	}
+}